@@ -1,14 +1,160 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type ErrAPIFailed struct {
-	Message string
+// FieldError describes a problem with a single field of a request, as reported by the API
+type FieldError struct {
 	Code    string
+	Message string
+	Path    string
+}
+
+type ErrAPIFailed struct {
+	Provider   string
+	StatusCode int
+	Code       string
+	Message    string
+	Fields     []FieldError
+	// Whether repeating the same call might succeed
+	Retryable bool
+	// Delay requested by the API through the Retry-After header, if any
+	RetryAfter time.Duration
 }
 
 func (e *ErrAPIFailed) Error() string {
-	return fmt.Sprintf("API call failed with code %s: %s", e.Code, e.Message)
+	msg := fmt.Sprintf("%s API call failed with status %d", e.Provider, e.StatusCode)
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s and code %s", msg, e.Code)
+	}
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	if len(e.Fields) > 0 {
+		fields := make([]string, 0, len(e.Fields))
+		for _, f := range e.Fields {
+			fields = append(fields, fmt.Sprintf("%s: %s", f.Path, f.Message))
+		}
+		msg = fmt.Sprintf("%s (%s)", msg, strings.Join(fields, "; "))
+	}
+	return msg
+}
+
+// ErrRequestFailed is returned when an API call could not be completed,
+// for example because of network errors or an unreadable response
+type ErrRequestFailed struct {
+	Provider string
+	Err      error
+}
+
+func (e *ErrRequestFailed) Error() string {
+	return fmt.Sprintf("%s API request failed: %s", e.Provider, e.Err)
+}
+
+func (e *ErrRequestFailed) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidResponse is returned when a successful API response cannot be decoded
+type ErrInvalidResponse struct {
+	Provider string
+	Err      error
+}
+
+func (e *ErrInvalidResponse) Error() string {
+	return fmt.Sprintf("%s API returned an invalid response: %s", e.Provider, e.Err)
+}
+
+func (e *ErrInvalidResponse) Unwrap() error {
+	return e.Err
+}
+
+type ErrMissingCredentials struct {
+	Provider string
+}
+
+func (e *ErrMissingCredentials) Error() string {
+	return fmt.Sprintf("No API credentials supplied for provider %s", e.Provider)
+}
+
+// API error codes which will never succeed when repeated
+var fatalCodes = map[string]bool{
+	"UNABLE_TO_AUTHENTICATE": true,
+	"ACCESS_DENIED":          true,
+	"INVALID_BODY":           true,
+	"NOT_FOUND":              true,
+}
+
+// IsRetryable reports whether a failed call is worth repeating.
+// Network errors, rate limiting and server side errors are retried,
+// while errors caused by the request itself (e.g. wrong credentials) are not
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *ErrAPIFailed
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// send performs an HTTP request and returns the response together with its body
+func send(client *http.Client, provider string, req *http.Request) (*http.Response, []byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, &ErrRequestFailed{Provider: provider, Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, &ErrRequestFailed{Provider: provider, Err: err}
+	}
+	return resp, body, nil
+}
+
+// newAPIError builds the error for a failed API call from its HTTP response.
+// Provider specific details can be added before calling classify
+func newAPIError(provider string, resp *http.Response) *ErrAPIFailed {
+	return &ErrAPIFailed{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// classify marks the error as retryable for rate limiting and server side errors,
+// unless the API reported a code which will never succeed
+func (e *ErrAPIFailed) classify() *ErrAPIFailed {
+	e.Retryable = !fatalCodes[e.Code] && (e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500)
+	return e
+}
+
+// parseRetryAfter supports both forms of the Retry-After header, delay in seconds and HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(header)
+	if err != nil || date.Before(now) {
+		return 0
+	}
+	return date.Sub(now)
 }
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sudneo/home-ddns/models"
+)
+
+func TestGodaddyFieldErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"code":"INVALID_BODY","message":"Request body doesn't fulfill schema","fields":[{"code":"UNEXPECTED_TYPE","message":"is not a number","path":"records[0].ttl"}]}`))
+	}))
+	defer server.Close()
+	handler := &GodaddyHandler{clientID: "id", clientKey: "key", client: server.Client(), baseURL: server.URL}
+	err := handler.SetRecord(context.Background(), "example.com", models.DNSRecord{Name: "home", Type: "A", Value: "1.2.3.4"})
+	var apiErr *ErrAPIFailed
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected ErrAPIFailed, got %v", err)
+	}
+	if apiErr.Provider != GodaddyProvider || apiErr.StatusCode != 422 || apiErr.Code != "INVALID_BODY" || apiErr.Retryable {
+		t.Errorf("Error not populated correctly: %+v", apiErr)
+	}
+	if len(apiErr.Fields) != 1 || apiErr.Fields[0].Path != "records[0].ttl" {
+		t.Errorf("Field errors not parsed: %+v", apiErr.Fields)
+	}
+}
+
+func TestPorkbunErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`<html>Bad Gateway</html>`))
+	}))
+	handler := &PorkbunHandler{clientID: "id", clientKey: "key", client: server.Client(), baseURL: server.URL}
+	err := handler.UpdateRecord(context.Background(), "example.com", models.DNSRecord{Name: "home", Type: "A", Value: "1.2.3.4"})
+	var apiErr *ErrAPIFailed
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 502 || !apiErr.Retryable {
+		t.Errorf("Expected retryable ErrAPIFailed for a non JSON 502, got %v", err)
+	}
+	// Once the server is gone, network errors must be returned rather than terminating the process
+	server.Close()
+	err = handler.SetRecord(context.Background(), "example.com", models.DNSRecord{Name: "home", Type: "A", Value: "1.2.3.4"})
+	var reqErr *ErrRequestFailed
+	if !errors.As(err, &reqErr) || !IsRetryable(err) {
+		t.Errorf("Expected retryable ErrRequestFailed, got %v", err)
+	}
+}
+
+func TestPorkbunPayload(t *testing.T) {
+	var sent map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "retrieveByNameType") {
+			w.Write([]byte(`{"status":"SUCCESS","records":[{"id":"1","name":"example.com","type":"MX","content":"mail.example.com","ttl":"3600","prio":"10"}]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Write([]byte(`{"status":"SUCCESS"}`))
+	}))
+	defer server.Close()
+	handler := &PorkbunHandler{clientID: "id", clientKey: "key", client: server.Client(), baseURL: server.URL}
+	record, err := handler.GetRecord(context.Background(), "example.com", models.DNSRecord{Name: "@", Type: "MX"})
+	if err != nil || record.Priority == nil || *record.Priority != 10 || record.TTL != 3600 {
+		t.Errorf("Unexpected record %+v, error %v", record, err)
+	}
+	priority := 20
+	if err := handler.SetRecord(context.Background(), "example.com", models.DNSRecord{Name: "@", Type: "MX", Value: "mail.example.com", Priority: &priority, TTL: 600}); err != nil {
+		t.Fatal(err)
+	}
+	if sent["prio"] != "20" {
+		t.Errorf("Expected the priority to be sent as a string, got %#v", sent["prio"])
+	}
+	if sent["ttl"] != "3600" {
+		t.Errorf("Expected the minimum TTL to be sent as a string, got %#v", sent["ttl"])
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	godaddy := ProviderCapabilities(GodaddyProvider)
+	for ttl, expected := range map[int]int{0: 0, 60: 600, 3600: 3600, 1000000: 604800} {
+		if clamped := godaddy.ClampTTL(ttl); clamped != expected {
+			t.Errorf("TTL %d clamped to %d, expected %d", ttl, clamped, expected)
+		}
+	}
+	if !godaddy.Supports("SRV") || godaddy.Supports("HTTPS") || !(Capabilities{}).Supports("HTTPS") {
+		t.Errorf("Unexpected supported types")
+	}
+	if data := godaddyPayload(models.DNSRecord{Type: "A", TTL: 1000000}); data[0].TTL != godaddyMaxTTL {
+		t.Errorf("TTL above the maximum sent as %d", data[0].TTL)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+// Record types managed by both providers
+var standardTypes = []string{"A", "AAAA", "CAA", "CNAME", "MX", "NS", "SRV", "TXT"}
+
+// Capabilities describes the constraints a provider applies to records
+type Capabilities struct {
+	// Lowest TTL accepted, lower values are raised to it
+	MinTTL int
+	// Highest TTL accepted, higher values are lowered to it, 0 means no limit
+	MaxTTL int
+	// Record types which can be managed, nil means any type
+	Types []string
+	// Whether a name can hold several values of a type, e.g. round-robin A records
+	MultiValue bool
+	// Whether several records can be changed with a single call
+	Batch bool
+	// Whether records can be deleted
+	Delete bool
+}
+
+// Supports reports whether records of a type can be managed
+func (c Capabilities) Supports(recordType string) bool {
+	if c.Types == nil {
+		return true
+	}
+	for _, t := range c.Types {
+		if t == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// ClampTTL returns the TTL the provider applies for a configured one, 0 (provider default) is kept
+func (c Capabilities) ClampTTL(ttl int) int {
+	if ttl == 0 {
+		return ttl
+	}
+	if ttl < c.MinTTL {
+		return c.MinTTL
+	}
+	if c.MaxTTL != 0 && ttl > c.MaxTTL {
+		return c.MaxTTL
+	}
+	return ttl
+}
+
+// Map of the capabilities of each provider, used to warn about the values changed by the provider and by the
+// handlers to keep the TTLs they send in range
+var capabilities = map[string]Capabilities{
+	GodaddyProvider: {
+		MinTTL:     godaddyMinTTL,
+		MaxTTL:     godaddyMaxTTL,
+		Types:      standardTypes,
+		MultiValue: true,
+		Batch:      true,
+		Delete:     true,
+	},
+	PorkbunProvider: {
+		MinTTL:     porkbunMinTTL,
+		Types:      standardTypes,
+		MultiValue: true,
+		Delete:     true,
+	},
+}
+
+// RegisterCapabilities declares the constraints of a provider, replacing any previous declaration
+func RegisterCapabilities(name string, c Capabilities) {
+	capabilities[name] = c
+}
+
+// ProviderCapabilities returns the constraints of a provider, the zero value when none were declared
+func ProviderCapabilities(name string) Capabilities {
+	return capabilities[name]
+}
@@ -2,22 +2,28 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/sudneo/home-ddns/models"
 )
 
 const (
 	godaddyAPIBaseURL = "https://api.godaddy.com"
+	// Lower TTLs, or no TTL at all, are sent as the minimum accepted by Godaddy
+	godaddyMinTTL = 600
+	// Higher TTLs are rejected by Godaddy, one week
+	godaddyMaxTTL = 604800
 )
 
 type GodaddyHandler struct {
-	ClientID  string
-	ClientKey string
+	clientID  string
+	clientKey string
+	client    *http.Client
+	baseURL   string
 }
 
 type godaddyErrorResponse struct {
@@ -44,67 +50,62 @@ type godaddyRecordData []struct {
 	Weight   int    `json:"weight"`
 }
 
-func (h *GodaddyHandler) SetAPIKey(key string) error {
-	h.ClientKey = key
-	return nil
+// NewGodaddyHandler builds a handler bound to the credentials of a single Godaddy account
+func NewGodaddyHandler(options Options) (models.Provider, error) {
+	if options.ClientID == "" || options.ClientKey == "" {
+		return nil, &ErrMissingCredentials{Provider: GodaddyProvider}
+	}
+	return &GodaddyHandler{
+		clientID:  options.ClientID,
+		clientKey: options.ClientKey,
+		client:    &http.Client{Timeout: options.Timeout},
+		baseURL:   godaddyAPIBaseURL,
+	}, nil
 }
 
-func (h *GodaddyHandler) SetAPIID(id string) error {
-	h.ClientID = id
-	return nil
-}
-
-// GetRecord implements Provider.GetRecord. Fetches from Godaddy API the information about an existing record
-func (h *GodaddyHandler) GetRecord(domain string, record models.DNSRecord) (dnsRecord models.DNSRecord, err error) {
-	var d models.DNSRecord
-	url := fmt.Sprintf("%s/v1/domains/%s/records/%s/%s", godaddyAPIBaseURL, domain, record.Type, record.Name)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	authHeader := fmt.Sprintf("sso-key %s:%s", h.ClientID, h.ClientKey)
-	req.Header.Add("Authorization", authHeader)
-	resp, err := client.Do(req)
-	if err != nil {
-		return d, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		response := godaddyErrorResponse{}
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return d, err
-		}
-		err = json.Unmarshal(body, &response)
+// do performs an authenticated call to the Godaddy API, sending payload (if any) as JSON.
+// The body of a successful response is returned, failed responses are turned into *ErrAPIFailed
+func (h *GodaddyHandler) do(ctx context.Context, method string, url string, payload interface{}) ([]byte, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
 		if err != nil {
-			return dnsRecord, err
+			return nil, err
 		}
-		return d, &ErrAPIFailed{Code: response.Code, Message: response.Message}
+		body = bytes.NewReader(data)
 	}
-	response := godaddyRecordData{}
-	body, err := io.ReadAll(resp.Body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		return d, err
+		return nil, err
 	}
-	err = json.Unmarshal(body, &response)
+	req.Header.Add("Authorization", fmt.Sprintf("sso-key %s:%s", h.clientID, h.clientKey))
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	resp, respBody, err := send(h.client, GodaddyProvider, req)
 	if err != nil {
-		return d, err
+		return nil, err
 	}
-	if len(response) == 0 {
-		return d, nil
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newAPIError(GodaddyProvider, resp)
+		response := godaddyErrorResponse{}
+		// Error responses which are not JSON (e.g. from a proxy) keep the generic status message
+		if json.Unmarshal(respBody, &response) == nil {
+			apiErr.Code = response.Code
+			if response.Message != "" {
+				apiErr.Message = response.Message
+			}
+			for _, f := range response.Fields {
+				apiErr.Fields = append(apiErr.Fields, FieldError{Code: f.Code, Message: f.Message, Path: f.Path})
+			}
+		}
+		return nil, apiErr.classify()
 	}
-	d.Name = response[0].Name
-	d.Value = response[0].Data
-	d.Type = response[0].Type
-	d.TTL = response[0].TTL
-	d.Weight = response[0].Weight
-	d.Service = response[0].Service
-	d.Protocol = response[0].Protocol
-	d.Port = response[0].Port
-	return d, nil
+	return respBody, nil
 }
 
-// SetRecord implements Provider.SetRecord. Creates a new DNS record as passed in parameters
-func (h *GodaddyHandler) SetRecord(domain string, record models.DNSRecord) (err error) {
-	url := fmt.Sprintf("%s/v1/domains/%s/records", godaddyAPIBaseURL, domain)
+// godaddyPayload converts a record in the structure expected by Godaddy API, applying its constraints
+func godaddyPayload(record models.DNSRecord) godaddyRecordData {
 	// We need an array because Godaddy API can modify multiple records at once
 	data := godaddyRecordData{
 		{
@@ -117,13 +118,13 @@ func (h *GodaddyHandler) SetRecord(domain string, record models.DNSRecord) (err
 	} else {
 		data[0].Port = record.Port
 	}
-	if record.TTL != 0 && record.TTL >= 600 {
-		data[0].TTL = record.TTL
-	} else {
-		data[0].TTL = 600
+	// Godaddy requires a TTL, its minimum stands for the provider default
+	data[0].TTL = ProviderCapabilities(GodaddyProvider).ClampTTL(record.TTL)
+	if data[0].TTL == 0 {
+		data[0].TTL = godaddyMinTTL
 	}
-	if record.Priority != 0 {
-		data[0].Priority = record.Priority
+	if record.Priority != nil {
+		data[0].Priority = *record.Priority
 	}
 	if record.Protocol != "" {
 		data[0].Protocol = record.Protocol
@@ -131,109 +132,51 @@ func (h *GodaddyHandler) SetRecord(domain string, record models.DNSRecord) (err
 	if record.Service != "" {
 		data[0].Service = record.Service
 	}
-	if record.Weight != 0 {
-		data[0].Weight = record.Weight
-	}
-	payload, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	client := &http.Client{}
-	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(payload))
-	authHeader := fmt.Sprintf("sso-key %s:%s", h.ClientID, h.ClientKey)
-	req.Header.Add("Authorization", authHeader)
-	req.Header.Add("Content-Type", "application/json")
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
+	if record.Weight != nil {
+		data[0].Weight = *record.Weight
 	}
-	if resp.StatusCode == 200 {
-		log.Info("Successfully created DNS record")
-		return nil
-	}
-	defer resp.Body.Close()
+	return data
+}
 
-	body, err := io.ReadAll(resp.Body)
-	fmt.Print(string(body))
+// GetRecord implements Provider.GetRecord. Fetches from Godaddy API the information about an existing record
+func (h *GodaddyHandler) GetRecord(ctx context.Context, domain string, record models.DNSRecord) (dnsRecord models.DNSRecord, err error) {
+	var d models.DNSRecord
+	url := fmt.Sprintf("%s/v1/domains/%s/records/%s/%s", h.baseURL, domain, record.Type, record.Name)
+	body, err := h.do(ctx, "GET", url, nil)
 	if err != nil {
-		log.Fatal(err)
+		return d, err
 	}
-
-	response := godaddyErrorResponse{}
+	response := godaddyRecordData{}
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return err
+		return d, &ErrInvalidResponse{Provider: GodaddyProvider, Err: err}
 	}
-	return &ErrAPIFailed{Code: response.Code, Message: response.Message}
+	if len(response) == 0 {
+		return d, nil
+	}
+	d.Name = response[0].Name
+	d.Value = response[0].Data
+	d.Type = response[0].Type
+	d.TTL = response[0].TTL
+	d.Weight = &response[0].Weight
+	d.Service = response[0].Service
+	d.Protocol = response[0].Protocol
+	d.Priority = &response[0].Priority
+	d.Port = response[0].Port
+	return d, nil
+}
+
+// SetRecord implements Provider.SetRecord. Creates a new DNS record as passed in parameters
+func (h *GodaddyHandler) SetRecord(ctx context.Context, domain string, record models.DNSRecord) (err error) {
+	url := fmt.Sprintf("%s/v1/domains/%s/records", h.baseURL, domain)
+	_, err = h.do(ctx, "PATCH", url, godaddyPayload(record))
+	return err
 }
 
 // UpdateRecord implements Provider.UpdateRecord. Updates an existing DNS record with a new configuration
 // Generally, this method is invoked when the IP changed
-func (h *GodaddyHandler) UpdateRecord(domain string, record models.DNSRecord) (err error) {
-	url := fmt.Sprintf("%s/v1/domains/%s/records/%s/%s", godaddyAPIBaseURL, domain, record.Type, record.Name)
-	data := godaddyRecordData{
-		{
-			Data: record.Value,
-			Name: record.Name,
-			Type: record.Type,
-		}}
-	if record.Port < 1 || record.Port > 65535 {
-		data[0].Port = 1
-	} else {
-		data[0].Port = record.Port
-	}
-	if record.TTL != 0 && record.TTL >= 600 {
-		data[0].TTL = record.TTL
-	} else {
-		data[0].TTL = 600
-	}
-	if record.Priority != 0 {
-		data[0].Priority = record.Priority
-	}
-	if record.Protocol != "" {
-		data[0].Protocol = record.Protocol
-	}
-	if record.Service != "" {
-		data[0].Service = record.Service
-	}
-	if record.Weight != 0 {
-		data[0].Weight = record.Weight
-	}
-	payload, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(payload))
-	authHeader := fmt.Sprintf("sso-key %s:%s", h.ClientID, h.ClientKey)
-	req.Header.Add("Authorization", authHeader)
-	req.Header.Add("Content-Type", "application/json")
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if resp.StatusCode == 200 {
-		log.Info("Successfully updated DNS record")
-		return nil
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	fmt.Print(string(body))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	response := godaddyErrorResponse{}
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return err
-	}
-	return &ErrAPIFailed{Code: response.Code, Message: response.Message}
+func (h *GodaddyHandler) UpdateRecord(ctx context.Context, domain string, record models.DNSRecord) (err error) {
+	url := fmt.Sprintf("%s/v1/domains/%s/records/%s/%s", h.baseURL, domain, record.Type, record.Name)
+	_, err = h.do(ctx, "PUT", url, godaddyPayload(record))
+	return err
 }
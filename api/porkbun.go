@@ -2,23 +2,26 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/sudneo/home-ddns/models"
 )
 
 const (
 	porkbunBaseURL = "https://api.porkbun.com"
+	// Lower TTLs are not accepted by Porkbun
+	porkbunMinTTL = 3600
 )
 
 type PorkbunHandler struct {
-	ClientID  string
-	ClientKey string
+	clientID  string
+	clientKey string
+	client    *http.Client
+	baseURL   string
 }
 
 type porkbunErrorResponse struct {
@@ -30,13 +33,13 @@ type porkbunErrorResponse struct {
 type porkbunRecordData struct {
 	Status  string `json:"status"`
 	Records []struct {
-		ID       string `json:"id"`
-		Name     string `json:"name"`
-		Type     string `json:"type"`
-		Value    string `json:"content"`
-		TTL      string `json:"ttl"`
-		Priority int    `json:"priority"`
-		Notes    string `json:"notes"`
+		ID       string      `json:"id"`
+		Name     string      `json:"name"`
+		Type     string      `json:"type"`
+		Value    string      `json:"content"`
+		TTL      string      `json:"ttl"`
+		Priority json.Number `json:"prio"` // A string, or null for records without priority
+		Notes    string      `json:"notes"`
 	}
 }
 
@@ -49,8 +52,8 @@ type porkbunUpdateRecordData struct {
 	ApiKey       string `json:"apikey"`
 	SecretApiKey string `json:"secretapikey"`
 	Content      string `json:"content"`
-	TTL          string `json:"ttl"`
-	Prio         int    `json:"prio"`
+	TTL          string `json:"ttl,omitempty"`
+	Prio         string `json:"prio,omitempty"`
 }
 
 type porkbunCreateRecordData struct {
@@ -59,60 +62,89 @@ type porkbunCreateRecordData struct {
 	Name         string `json:"name"`
 	Recordtype   string `json:"type"`
 	Content      string `json:"content"`
-	TTL          string `json:"ttl"`
-	Prio         int    `json:"prio"`
+	TTL          string `json:"ttl,omitempty"`
+	Prio         string `json:"prio,omitempty"`
 }
 
-func (h *PorkbunHandler) SetAPIKey(key string) error {
-	h.ClientKey = key
-	return nil
+// NewPorkbunHandler builds a handler bound to the credentials of a single Porkbun account
+func NewPorkbunHandler(options Options) (models.Provider, error) {
+	if options.ClientID == "" || options.ClientKey == "" {
+		return nil, &ErrMissingCredentials{Provider: PorkbunProvider}
+	}
+	return &PorkbunHandler{
+		clientID:  options.ClientID,
+		clientKey: options.ClientKey,
+		client:    &http.Client{Timeout: options.Timeout},
+		baseURL:   porkbunBaseURL,
+	}, nil
 }
 
-func (h *PorkbunHandler) SetAPIID(id string) error {
-	h.ClientID = id
-	return nil
-}
-
-// GetRecord implements Provider.GetRecord. Fetches from Porkbun API the information about an existing record
-func (h *PorkbunHandler) GetRecord(domain string, record models.DNSRecord) (dnsRecord models.DNSRecord, err error) {
-	var d models.DNSRecord
-	data := porkbunAuthData{
-		ApiKey:       h.ClientID,
-		SecretApiKey: h.ClientKey,
+// do performs a call to the Porkbun API, which always expects a JSON payload carrying the credentials.
+// The body of a successful response is returned, failed responses are turned into *ErrAPIFailed
+func (h *PorkbunHandler) do(ctx context.Context, url string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
 	}
-	jsonBody, err := json.Marshal(data)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
 	if err != nil {
-		return d, err
+		return nil, err
 	}
-	url := fmt.Sprintf("%s/api/json/v3/dns/retrieveByNameType/%s/%s/%s", porkbunBaseURL, domain, record.Type, record.Name)
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	resp, body, err := send(h.client, PorkbunProvider, req)
 	if err != nil {
-		return d, err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		response := porkbunErrorResponse{}
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return d, err
-		}
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			return dnsRecord, err
+	response := porkbunErrorResponse{}
+	decodeErr := json.Unmarshal(body, &response)
+	if resp.StatusCode != http.StatusOK || response.Status == "ERROR" {
+		apiErr := newAPIError(PorkbunProvider, resp)
+		// Error responses which are not JSON (e.g. from a proxy) keep the generic status message
+		if decodeErr == nil {
+			apiErr.Code = response.Status
+			if response.Message != "" {
+				apiErr.Message = response.Message
+			}
 		}
-		return d, &ErrAPIFailed{Code: response.Status, Message: response.Message}
+		return nil, apiErr.classify()
 	}
-	response := porkbunRecordData{}
-	body, err := io.ReadAll(resp.Body)
+	return body, nil
+}
+
+// porkbunTTL converts the TTL in the format expected by Porkbun API, within the range of its capabilities.
+// The TTL is sent as a decimal string, omitted for the provider default
+func porkbunTTL(ttl int) string {
+	ttl = ProviderCapabilities(PorkbunProvider).ClampTTL(ttl)
+	if ttl == 0 {
+		return ""
+	}
+	return strconv.Itoa(ttl)
+}
+
+// porkbunPriority converts the priority in the format expected by Porkbun API, omitted when not set
+func porkbunPriority(priority *int) string {
+	if priority == nil {
+		return ""
+	}
+	return strconv.Itoa(*priority)
+}
+
+// GetRecord implements Provider.GetRecord. Fetches from Porkbun API the information about an existing record
+func (h *PorkbunHandler) GetRecord(ctx context.Context, domain string, record models.DNSRecord) (dnsRecord models.DNSRecord, err error) {
+	var d models.DNSRecord
+	data := porkbunAuthData{
+		ApiKey:       h.clientID,
+		SecretApiKey: h.clientKey,
+	}
+	url := fmt.Sprintf("%s/api/json/v3/dns/retrieveByNameType/%s/%s/%s", h.baseURL, domain, record.Type, record.Name)
+	body, err := h.do(ctx, url, data)
 	if err != nil {
 		return d, err
 	}
+	response := porkbunRecordData{}
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return d, err
+		return d, &ErrInvalidResponse{Provider: PorkbunProvider, Err: err}
 	}
 	if len(response.Records) == 0 {
 		return d, nil
@@ -120,117 +152,41 @@ func (h *PorkbunHandler) GetRecord(domain string, record models.DNSRecord) (dnsR
 	d.Name = response.Records[0].Name
 	d.Value = response.Records[0].Value
 	d.Type = response.Records[0].Type
-	ttl, err := strconv.Atoi(response.Records[0].TTL)
-	if err != nil {
-		d.TTL = 0
+	// An unparsable TTL is left as 0, it is not used for drift detection
+	d.TTL, _ = strconv.Atoi(response.Records[0].TTL)
+	if priority, err := strconv.Atoi(response.Records[0].Priority.String()); err == nil {
+		d.Priority = &priority
 	}
-	d.TTL = ttl
 	return d, nil
 }
 
 // UpdateRecord implements Provider.UpdateRecord. Updates an existing DNS record with a new configuration
 // Generally, this method is invoked when the IP changed
-func (h *PorkbunHandler) UpdateRecord(domain string, record models.DNSRecord) (err error) {
-	url := fmt.Sprintf("%s/api/json/v3/dns/editByNameType/%s/%s/%s", porkbunBaseURL, domain, record.Type, record.Name)
+func (h *PorkbunHandler) UpdateRecord(ctx context.Context, domain string, record models.DNSRecord) (err error) {
+	url := fmt.Sprintf("%s/api/json/v3/dns/editByNameType/%s/%s/%s", h.baseURL, domain, record.Type, record.Name)
 	data := porkbunUpdateRecordData{
-		ApiKey:       h.ClientID,
-		SecretApiKey: h.ClientKey,
+		ApiKey:       h.clientID,
+		SecretApiKey: h.clientKey,
 		Content:      record.Value,
+		TTL:          porkbunTTL(record.TTL),
+		Prio:         porkbunPriority(record.Priority),
 	}
-	if record.TTL != 0 && record.TTL < 3600 {
-		data.TTL = "3600"
-	} else {
-		data.TTL = fmt.Sprintf("%d", record.TTL)
-	}
-	if record.Priority != 0 {
-		data.Prio = record.Priority
-	}
-
-	payload, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Add("Content-Type", "application/json")
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if resp.StatusCode == 200 {
-		log.Info("Successfully created DNS record")
-		return nil
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	fmt.Print(string(body))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	response := porkbunErrorResponse{}
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return err
-	}
-	return &ErrAPIFailed{Code: response.Status, Message: response.Message}
+	_, err = h.do(ctx, url, data)
+	return err
 }
 
 // SetRecord implements Provider.SetRecord. Creates a new DNS record as passed in parameters
-func (h *PorkbunHandler) SetRecord(domain string, record models.DNSRecord) (err error) {
-	url := fmt.Sprintf("%s/api/json/v3/dns/create/%s", porkbunBaseURL, domain)
+func (h *PorkbunHandler) SetRecord(ctx context.Context, domain string, record models.DNSRecord) (err error) {
+	url := fmt.Sprintf("%s/api/json/v3/dns/create/%s", h.baseURL, domain)
 	data := porkbunCreateRecordData{
-		SecretApiKey: h.ClientKey,
-		ApiKey:       h.ClientID,
+		SecretApiKey: h.clientKey,
+		ApiKey:       h.clientID,
 		Name:         record.Name,
 		Recordtype:   record.Type,
 		Content:      record.Value,
+		TTL:          porkbunTTL(record.TTL),
+		Prio:         porkbunPriority(record.Priority),
 	}
-
-	if record.TTL != 0 && record.TTL < 3600 {
-		data.TTL = "3600"
-	} else {
-		data.TTL = string(record.TTL)
-	}
-
-	if record.Priority != 0 {
-		data.Prio = record.Priority
-	}
-	payload, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Add("Content-Type", "application/json")
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if resp.StatusCode == 200 {
-		log.Info("Successfully updated DNS record")
-		return nil
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	fmt.Print(string(body))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	response := porkbunErrorResponse{}
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		fmt.Print(string(body))
-		return err
-	}
-	return &ErrAPIFailed{Code: response.Status, Message: response.Message}
+	_, err = h.do(ctx, url, data)
+	return err
 }
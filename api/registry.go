@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sudneo/home-ddns/models"
+)
+
+const (
+	GodaddyProvider = "Godaddy"
+	PorkbunProvider = "Porkbun"
+)
+
+// Options holds the settings of a single configured provider account
+type Options struct {
+	ClientID  string
+	ClientKey string
+	// Maximum duration of a single API call, zero means no limit
+	Timeout time.Duration
+	// Policy applied to failed API calls, unset values take the defaults
+	Retry RetryPolicy
+	// Optional wrapper of the handler applied below the retries, so that it sees every attempt
+	Instrument func(models.Provider) models.Provider
+}
+
+// Factory builds a new handler for a provider account from its options
+type Factory func(options Options) (models.Provider, error)
+
+type ErrUnknownProvider struct {
+	Name string
+}
+
+func (e *ErrUnknownProvider) Error() string {
+	return fmt.Sprintf("Provider %s is not registered", e.Name)
+}
+
+// Map to register providers
+// Each name (used in the config) is matched
+// with the constructor of the corresponding handler type
+var factories = map[string]Factory{
+	GodaddyProvider: NewGodaddyHandler,
+	PorkbunProvider: NewPorkbunHandler,
+}
+
+// Register makes a provider available under the given name, replacing any previous registration
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// IsRegistered reports whether a provider with the given name exists
+func IsRegistered(name string) bool {
+	_, ok := factories[name]
+	return ok
+}
+
+// Providers returns the sorted names of all the registered providers
+func Providers() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider builds a fresh handler for the provider registered with the given name
+func NewProvider(name string, options Options) (models.Provider, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, &ErrUnknownProvider{Name: name}
+	}
+	provider, err := factory(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.Instrument != nil {
+		provider = options.Instrument(provider)
+	}
+	policy := options.Retry.WithDefaults()
+	if policy.MaxAttempts > 1 {
+		provider = &retryingProvider{provider: provider, policy: policy}
+	}
+	return provider, nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/sudneo/home-ddns/models"
+)
+
+func TestNewProvider(t *testing.T) {
+	first, err := NewProvider(GodaddyProvider, Options{ClientID: "id1", ClientKey: "key1", Retry: RetryPolicy{MaxAttempts: 1}})
+	if err != nil {
+		t.Fatalf("Building a Godaddy handler lead to error: %s", err)
+	}
+	second, err := NewProvider(GodaddyProvider, Options{ClientID: "id2", ClientKey: "key2", Retry: RetryPolicy{MaxAttempts: 1}})
+	if err != nil {
+		t.Fatalf("Building a second Godaddy handler lead to error: %s", err)
+	}
+	if first.(*GodaddyHandler).clientKey != "key1" || second.(*GodaddyHandler).clientKey != "key2" {
+		t.Errorf("Handlers for different accounts share credentials")
+	}
+	// The instrumentation is applied below the retries
+	var instrumented models.Provider
+	wrapped, err := NewProvider(GodaddyProvider, Options{ClientID: "id", ClientKey: "key", Instrument: func(handler models.Provider) models.Provider {
+		instrumented = handler
+		return &flakyProvider{}
+	}})
+	if err != nil {
+		t.Fatalf("Building an instrumented handler lead to error: %s", err)
+	}
+	if _, ok := instrumented.(*GodaddyHandler); !ok {
+		t.Errorf("Instrumented %T instead of the Godaddy handler", instrumented)
+	}
+	if retrying, ok := wrapped.(*retryingProvider); !ok {
+		t.Errorf("Retries not applied to %T", wrapped)
+	} else if _, ok := retrying.provider.(*flakyProvider); !ok {
+		t.Errorf("Retries applied to %T instead of the instrumentation", retrying.provider)
+	}
+	_, err = NewProvider(PorkbunProvider, Options{ClientID: "id"})
+	if err == nil {
+		t.Errorf("Building a handler without API key did not error")
+	}
+	_, err = NewProvider("Unknown", Options{ClientID: "id", ClientKey: "key"})
+	if _, ok := err.(*ErrUnknownProvider); !ok {
+		t.Errorf("Building an unknown provider returned %v instead of ErrUnknownProvider", err)
+	}
+}
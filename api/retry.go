@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sudneo/home-ddns/models"
+	"github.com/sudneo/home-ddns/rdata"
+)
+
+// RetryPolicy describes how failed API calls are retried.
+// Unset values are replaced by the ones in DefaultRetryPolicy, the fields which can be 0 are pointers
+// so that an explicit 0 is kept
+type RetryPolicy struct {
+	// Total number of attempts, including the first one. 1 disables retries
+	MaxAttempts int `yaml:"max_attempts"`
+	// Delay before the first retry
+	InitialBackoff *time.Duration `yaml:"initial_backoff"`
+	// Upper bound of the delay between two attempts
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// Factor applied to the delay after every attempt
+	Multiplier *float64 `yaml:"multiplier"`
+	// Fraction of the delay randomly added or removed, between 0 and 1
+	Jitter *float64 `yaml:"jitter"`
+}
+
+var (
+	defaultInitialBackoff = time.Second
+	defaultMultiplier     = 2.0
+	defaultJitter         = 0.2
+)
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: &defaultInitialBackoff,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     &defaultMultiplier,
+	Jitter:         &defaultJitter,
+}
+
+// WithDefaults returns a copy of the policy where unset values are taken from DefaultRetryPolicy
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoff == nil {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Multiplier == nil {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Jitter == nil {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	return p
+}
+
+// Validate reports values which make the policy unusable
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return errors.New("max_attempts must not be negative")
+	case (p.InitialBackoff != nil && *p.InitialBackoff < 0) || p.MaxBackoff < 0:
+		return errors.New("backoff durations must not be negative")
+	case p.Multiplier != nil && *p.Multiplier < 1:
+		return errors.New("multiplier must be at least 1")
+	case p.Jitter != nil && (*p.Jitter < 0 || *p.Jitter > 1):
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// backoff computes the delay before the given retry (starting from 1), unset values take the defaults
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	p = p.WithDefaults()
+	delay := float64(*p.InitialBackoff) * math.Pow(*p.Multiplier, float64(retry-1))
+	if delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	delay += delay * *p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// Do executes op until it succeeds, fails with an error which is not retryable
+// or the maximum number of attempts is reached. The last error is returned
+func (p RetryPolicy) Do(ctx context.Context, op func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil || attempt >= p.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+		delay := p.backoff(attempt)
+		var apiErr *ErrAPIFailed
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		// Do not wait if the deadline would expire before the next attempt
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"Error":   err,
+			"Attempt": attempt,
+			"Delay":   delay,
+		}).Warn("API call failed, retrying")
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryingProvider wraps a provider handler applying a retry policy to every call
+type retryingProvider struct {
+	provider models.Provider
+	policy   RetryPolicy
+}
+
+func (r *retryingProvider) GetRecord(ctx context.Context, domain string, record models.DNSRecord) (dnsRecord models.DNSRecord, err error) {
+	err = r.policy.Do(ctx, func() error {
+		dnsRecord, err = r.provider.GetRecord(ctx, domain, record)
+		return err
+	})
+	return dnsRecord, err
+}
+
+// SetRecord creates a record. Creating is not idempotent, a call whose response was lost may have added the
+// record already, so after such a failure the record is looked up before trying again
+func (r *retryingProvider) SetRecord(ctx context.Context, domain string, record models.DNSRecord) error {
+	var last error
+	return r.policy.Do(ctx, func() error {
+		if last != nil && !notSent(last) {
+			current, err := r.provider.GetRecord(ctx, domain, record)
+			if err != nil {
+				return err
+			}
+			// Providers may serve the value written differently, e.g. with a trailing dot or quoted
+			if current.Value != "" && rdata.Equal(record.Type, domain, current.Value, record.Value) {
+				return nil
+			}
+			if current.Value != "" {
+				return fmt.Errorf("%s, and the record now has value %s", last, current.Value)
+			}
+		}
+		last = r.provider.SetRecord(ctx, domain, record)
+		return last
+	})
+}
+
+// notSent reports whether a failed call provably did not reach the provider: it was rate limited,
+// or the connection could not be established
+func notSent(err error) bool {
+	var apiErr *ErrAPIFailed
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (r *retryingProvider) UpdateRecord(ctx context.Context, domain string, record models.DNSRecord) error {
+	return r.policy.Do(ctx, func() error {
+		return r.provider.UpdateRecord(ctx, domain, record)
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sudneo/home-ddns/models"
+)
+
+var (
+	testInitialBackoff = time.Millisecond
+	testMultiplier     = 2.0
+	testJitter         = 0.1
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: &testInitialBackoff,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     &testMultiplier,
+	Jitter:         &testJitter,
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{(&ErrAPIFailed{StatusCode: 503}).classify(), true},
+		{(&ErrAPIFailed{StatusCode: 429, Code: "TOO_MANY_REQUESTS"}).classify(), true},
+		{(&ErrAPIFailed{StatusCode: 401, Code: "UNABLE_TO_AUTHENTICATE"}).classify(), false},
+		{(&ErrAPIFailed{StatusCode: 500, Code: "UNABLE_TO_AUTHENTICATE"}).classify(), false},
+		{(&ErrAPIFailed{StatusCode: 422, Code: "INVALID_BODY"}).classify(), false},
+		{&ErrRequestFailed{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{context.Canceled, false},
+		{errors.New("invalid character"), false},
+	}
+	for _, c := range cases {
+		if IsRetryable(c.err) != c.retryable {
+			t.Errorf("Expected retryable %t for %v", c.retryable, c.err)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	attempts := 0
+	err := testRetryPolicy.Do(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return &ErrAPIFailed{StatusCode: 502, Retryable: true}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("Expected success after 3 attempts, got %v after %d", err, attempts)
+	}
+	attempts = 0
+	err = testRetryPolicy.Do(context.Background(), func() error {
+		attempts++
+		return &ErrAPIFailed{StatusCode: 401, Code: "UNABLE_TO_AUTHENTICATE"}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Fatal error was retried %d times", attempts)
+	}
+	attempts = 0
+	err = testRetryPolicy.Do(context.Background(), func() error {
+		attempts++
+		return &ErrAPIFailed{StatusCode: 500, Retryable: true}
+	})
+	if err == nil || attempts != testRetryPolicy.MaxAttempts {
+		t.Errorf("Expected %d attempts, got %d", testRetryPolicy.MaxAttempts, attempts)
+	}
+	// A Retry-After longer than the remaining deadline stops the retries
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	attempts = 0
+	err = testRetryPolicy.Do(ctx, func() error {
+		attempts++
+		return &ErrAPIFailed{StatusCode: 429, Retryable: true, RetryAfter: time.Minute}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Retry-After beyond the deadline was not respected, %d attempts", attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("120", now); d != 2*time.Minute {
+		t.Errorf("Expected 2m from seconds, got %s", d)
+	}
+	if d := parseRetryAfter("Mon, 01 Jan 2024 00:00:30 GMT", now); d != 30*time.Second {
+		t.Errorf("Expected 30s from HTTP date, got %s", d)
+	}
+	if d := parseRetryAfter("soon", now); d != 0 {
+		t.Errorf("Expected no delay from invalid header, got %s", d)
+	}
+}
+
+func TestRetryingProvider(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"code":"TOO_MANY_REQUESTS","message":"slow down"}`))
+			return
+		}
+		w.Write([]byte(`[{"data":"1.2.3.4","name":"home","type":"A","ttl":600}]`))
+	}))
+	defer server.Close()
+	handler := &GodaddyHandler{clientID: "id", clientKey: "key", client: server.Client(), baseURL: server.URL}
+	provider := &retryingProvider{provider: handler, policy: testRetryPolicy}
+	record, err := provider.GetRecord(context.Background(), "example.com", models.DNSRecord{Name: "home", Type: "A"})
+	if err != nil {
+		t.Fatalf("Retried call failed: %s", err)
+	}
+	if calls != 2 || record.Value != "1.2.3.4" {
+		t.Errorf("Expected value 1.2.3.4 after 2 calls, got %q after %d", record.Value, calls)
+	}
+}
+
+// flakyProvider applies every create, but loses the responses of the first ones
+type flakyProvider struct {
+	created []models.DNSRecord
+	lost    int
+	err     error
+	// Value served for the created records, when the provider writes it differently
+	served string
+}
+
+func (p *flakyProvider) GetRecord(_ context.Context, _ string, record models.DNSRecord) (models.DNSRecord, error) {
+	for _, created := range p.created {
+		if created.Name == record.Name && created.Type == record.Type {
+			if p.served != "" {
+				created.Value = p.served
+			}
+			return created, nil
+		}
+	}
+	return models.DNSRecord{}, nil
+}
+
+func (p *flakyProvider) SetRecord(_ context.Context, _ string, record models.DNSRecord) error {
+	if p.err != nil && p.lost > 0 {
+		p.lost--
+		if !notSent(p.err) {
+			p.created = append(p.created, record)
+		}
+		return p.err
+	}
+	p.created = append(p.created, record)
+	return nil
+}
+
+func (p *flakyProvider) UpdateRecord(_ context.Context, _ string, _ models.DNSRecord) error {
+	return nil
+}
+
+func TestRetryingProviderCreate(t *testing.T) {
+	record := models.DNSRecord{Name: "home", Type: "A", Value: "192.0.2.1"}
+	timeout := &ErrRequestFailed{Err: &net.OpError{Op: "read", Err: errors.New("i/o timeout")}}
+	refused := &ErrRequestFailed{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	for _, err := range []error{timeout, refused, (&ErrAPIFailed{StatusCode: 429}).classify(), (&ErrAPIFailed{StatusCode: 504}).classify()} {
+		handler := &flakyProvider{lost: 2, err: err}
+		provider := &retryingProvider{provider: handler, policy: testRetryPolicy}
+		if err := provider.SetRecord(context.Background(), "example.com", record); err != nil {
+			t.Errorf("Create failing with %v lead to error: %s", handler.err, err)
+		}
+		if len(handler.created) != 1 {
+			t.Errorf("Create failing with %v created %d records", handler.err, len(handler.created))
+		}
+	}
+	// The created value is recognized when the provider serves it in another form
+	handler := &flakyProvider{lost: 1, err: timeout, served: "Example.com."}
+	provider := &retryingProvider{provider: handler, policy: testRetryPolicy}
+	if err := provider.SetRecord(context.Background(), "example.com", models.DNSRecord{Name: "www", Type: "CNAME", Value: "example.com"}); err != nil || len(handler.created) != 1 {
+		t.Errorf("Create of a value served differently lead to %d records and error: %v", len(handler.created), err)
+	}
+}
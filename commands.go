@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	log "github.com/sirupsen/logrus"
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/config"
+	"github.com/sudneo/home-ddns/history"
+)
+
+// parseTime accepts either an RFC 3339 timestamp or a duration, meaning that long ago
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected an RFC 3339 timestamp or a duration", value)
+	}
+	return now.Add(-d), nil
+}
+
+// historyCommand implements `home-ddns history`, printing the recorded IP changes and record mutations
+func historyCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	var configuration = fs.String("config", "config.yaml", "Configuration file defining the history store")
+	var file = fs.String("file", "", "History file to query instead of the one in the configuration")
+	var backend = fs.String("backend", "", "Backend of -file: jsonl or bolt (default based on the extension)")
+	var record = fs.String("record", "", "Only show mutations of this record, e.g. home or home.example.com")
+	var domain = fs.String("domain", "", "Only show mutations of records in this domain")
+	var kind = fs.String("kind", "", "Only show entries of this kind: ip_change or mutation")
+	var since = fs.String("since", "", "Only show entries after this time, as RFC 3339 timestamp or duration ago (e.g. 168h)")
+	var until = fs.String("until", "", "Only show entries before this time, as RFC 3339 timestamp or duration ago")
+	var format = fs.String("format", "table", "Output format: table or json")
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+
+	storeConf := history.Configuration{Path: *file, Backend: *backend}
+	if *file == "" {
+		conf, err := config.ReadHistory(*configuration)
+		if err != nil {
+			logConfigError(err)
+			return exitConfigError
+		}
+		if !conf.Enabled() {
+			log.Errorf("No history configured in %s", *configuration)
+			return exitConfigError
+		}
+		storeConf = conf
+	}
+	filter := history.Filter{Record: *record, Domain: *domain, Kind: history.Kind(*kind)}
+	var err error
+	now := time.Now()
+	if filter.Since, err = parseTime(*since, now); err == nil {
+		filter.Until, err = parseTime(*until, now)
+	}
+	if err == nil && filter.Kind != "" && filter.Kind != history.IPChange && filter.Kind != history.Mutation {
+		err = fmt.Errorf("unknown kind %s", filter.Kind)
+	}
+	if err == nil && *format != "table" && *format != "json" {
+		err = fmt.Errorf("unknown format %s", *format)
+	}
+	if err != nil {
+		log.Error(err)
+		return exitConfigError
+	}
+	store, err := history.Open(storeConf)
+	if err != nil {
+		log.Error(err)
+		return exitConfigError
+	}
+	entries, err := store.Query(filter)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+			"File":  storeConf.Path,
+		}).Error("Failed to read the history")
+		return exitConfigError
+	}
+	if *format == "json" {
+		err = history.WriteJSON(os.Stdout, entries)
+	} else {
+		err = history.WriteTable(os.Stdout, entries)
+	}
+	if err != nil {
+		log.Error(err)
+		return exitConfigError
+	}
+	return exitSuccess
+}
+
+// stringList is a flag which can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// flagExitCode maps an error parsing the command line to the exit code of the process.
+// The flag package already printed the error and the usage
+func flagExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitSuccess
+	}
+	return exitConfigError
+}
+
+// parseFlags parses the flags of a command wherever they are, so that they can follow its arguments as in
+// `config lint config.yaml -strict`. Arguments after -- are never taken as flags
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	// Leaves the arguments in fs.Args(), flag values already set are kept
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+// configArgument sets the configuration file from the argument of a command, if any.
+// It fails when more than one argument is given
+func configArgument(fs *flag.FlagSet, configuration *string) error {
+	switch fs.NArg() {
+	case 0:
+		return nil
+	case 1:
+		*configuration = fs.Arg(0)
+		return nil
+	}
+	return fmt.Errorf("expected a single configuration file, got %s", strings.Join(fs.Args(), " "))
+}
+
+// commandError prints the failure of a config command to stderr, one line for each problem of an invalid
+// configuration, and returns the exit code of the command
+func commandError(err error) int {
+	var invalid *config.InvalidConfiguration
+	if errors.As(err, &invalid) && len(invalid.Problems) > 0 {
+		for _, problem := range invalid.Problems {
+			fmt.Fprintf(os.Stderr, "%s\n", problem)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	}
+	return exitConfigError
+}
+
+// readInput reads the file given as only argument of a command, or stdin
+func readInput(fs *flag.FlagSet) ([]byte, error) {
+	switch fs.NArg() {
+	case 0:
+		return ioutil.ReadAll(os.Stdin)
+	case 1:
+		return ioutil.ReadFile(fs.Arg(0))
+	}
+	return nil, fmt.Errorf("expected a single file, got %s", strings.Join(fs.Args(), " "))
+}
+
+// writeOutput writes the result of a command to a file, or stdout when empty
+func writeOutput(path string, data []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// formatFlag adds the flag selecting the format of the configuration files to a command
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", "", "Format of the configuration: yaml, json or toml (default from the file extension)")
+}
+
+// configEncryptCommand implements `home-ddns config encrypt`, encrypting a configuration with age
+func configEncryptCommand(args []string) int {
+	fs := flag.NewFlagSet("config encrypt", flag.ContinueOnError)
+	var recipients stringList
+	fs.Var(&recipients, "recipient", "age public key the configuration is encrypted to, can be repeated")
+	var recipientsFile = fs.String("recipients-file", "", "File with the age public keys the configuration is encrypted to, one per line")
+	var output = fs.String("o", "", "File to write the encrypted configuration to (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: home-ddns config encrypt -recipient age1... [config.yaml]\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+	var parsed []age.Recipient
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return commandError(err)
+		}
+		parsed = append(parsed, r)
+	}
+	if *recipientsFile != "" {
+		f, err := os.Open(*recipientsFile)
+		if err != nil {
+			return commandError(err)
+		}
+		r, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return commandError(err)
+		}
+		parsed = append(parsed, r...)
+	}
+	if len(parsed) == 0 {
+		return commandError(errors.New("at least one recipient is required"))
+	}
+	data, err := readInput(fs)
+	if err != nil {
+		return commandError(err)
+	}
+	if config.IsEncrypted(data) {
+		return commandError(errors.New("configuration is already encrypted"))
+	}
+	encrypted, err := config.Encrypt(data, parsed)
+	if err == nil {
+		err = writeOutput(*output, encrypted)
+	}
+	if err != nil {
+		return commandError(err)
+	}
+	return exitSuccess
+}
+
+// configDecryptCommand implements `home-ddns config decrypt`, printing the plain text of an encrypted configuration
+func configDecryptCommand(args []string) int {
+	fs := flag.NewFlagSet("config decrypt", flag.ContinueOnError)
+	var output = fs.String("o", "", "File to write the decrypted configuration to (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: home-ddns config decrypt [config.yaml.age]\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+	data, err := readInput(fs)
+	if err != nil {
+		return commandError(err)
+	}
+	if !config.IsEncrypted(data) {
+		return commandError(errors.New("configuration is not encrypted"))
+	}
+	plain, err := config.Decrypt(data)
+	if err == nil {
+		err = writeOutput(*output, plain)
+	}
+	if err != nil {
+		return commandError(err)
+	}
+	return exitSuccess
+}
+
+// configLintCommand implements `home-ddns config lint`, validating a configuration and
+// warning about values which will be silently changed
+func configLintCommand(args []string) int {
+	fs := flag.NewFlagSet("config lint", flag.ContinueOnError)
+	var configuration = fs.String("config", "config.yaml", "Configuration file to lint")
+	var strict = fs.Bool("strict", false, "Fail on warnings too")
+	var formatName = formatFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := configArgument(fs, configuration); err != nil {
+		return commandError(err)
+	}
+	format, err := config.ParseFormat(*formatName)
+	if err != nil {
+		return commandError(err)
+	}
+	_, warnings, err := config.Lint(*configuration, format)
+	if err != nil {
+		return commandError(err)
+	}
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	if *strict && len(warnings) > 0 {
+		return exitConfigError
+	}
+	if len(warnings) == 0 {
+		fmt.Printf("%s is valid\n", *configuration)
+	}
+	return exitSuccess
+}
+
+// prompt asks a question on the terminal, returning the default when the answer is empty
+func prompt(reader *bufio.Reader, question string, def string) string {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// isTerminal returns whether stdin is interactive
+func isTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// configInitCommand implements `home-ddns config init`, writing a commented starter configuration.
+// Missing settings are asked interactively when running in a terminal
+func configInitCommand(args []string) int {
+	fs := flag.NewFlagSet("config init", flag.ContinueOnError)
+	var provider = fs.String("provider", "", fmt.Sprintf("DNS provider of the domain: %s", strings.Join(api.Providers(), ", ")))
+	var domain = fs.String("domain", "", "Domain to manage, e.g. example.com")
+	var records = fs.String("records", "", "Comma separated names of the A records pointing to the public IP (default @)")
+	var output = fs.String("o", "config.yaml", "File to write, - for stdout")
+	var force = fs.Bool("force", false, "Overwrite the file if it exists")
+	var formatName = fs.String("format", "", "Format of the configuration: yaml, json or toml (default from the extension of -o)")
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+	format, err := config.ParseFormat(*formatName)
+	if err != nil {
+		return commandError(err)
+	}
+	if format == "" {
+		format = config.FormatOf(*output)
+	}
+	if (*provider == "" || *domain == "") && isTerminal() {
+		reader := bufio.NewReader(os.Stdin)
+		if *provider == "" {
+			*provider = prompt(reader, fmt.Sprintf("Provider (%s)", strings.Join(api.Providers(), ", ")), api.Providers()[0])
+		}
+		if *domain == "" {
+			*domain = prompt(reader, "Domain", "")
+		}
+		if *records == "" {
+			*records = prompt(reader, "Records pointing to the public IP, comma separated", "@")
+		}
+	}
+	if *provider == "" || *domain == "" {
+		return commandError(errors.New("-provider and -domain are required"))
+	}
+	options := config.StarterOptions{Provider: *provider, Domain: *domain}
+	for _, record := range strings.Split(*records, ",") {
+		if record = strings.TrimSpace(record); record != "" {
+			options.Records = append(options.Records, record)
+		}
+	}
+	data, err := config.Starter(options)
+	if err == nil && format != config.YAML {
+		// The comments of the starter configuration are only kept in YAML
+		data, err = config.Convert(data, config.YAML, format)
+	}
+	if err != nil {
+		return commandError(err)
+	}
+	if *output == "-" {
+		os.Stdout.Write(data)
+		return exitSuccess
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return commandError(fmt.Errorf("%s already exists, use -force to overwrite it", *output))
+	}
+	if err := ioutil.WriteFile(*output, data, 0600); err != nil {
+		return commandError(err)
+	}
+	fmt.Fprintf(os.Stderr, "Configuration written to %s, set the credentials before running home-ddns\n", *output)
+	return exitSuccess
+}
+
+func configShowCommand(args []string) int {
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	var configuration = fs.String("config", "config.yaml", "Configuration file to show")
+	var resolved = fs.Bool("resolved", false, "Show the configuration as applied, with the defaults merged into the records")
+	var formatName = formatFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := configArgument(fs, configuration); err != nil {
+		return commandError(err)
+	}
+	format, err := config.ParseFormat(*formatName)
+	if err != nil {
+		return commandError(err)
+	}
+	data, err := config.Show(*configuration, format, *resolved)
+	if err != nil {
+		return commandError(err)
+	}
+	os.Stdout.Write(data)
+	return exitSuccess
+}
+
+// configConvertCommand implements `home-ddns config convert`, translating a configuration between formats
+func configConvertCommand(args []string) int {
+	fs := flag.NewFlagSet("config convert", flag.ContinueOnError)
+	var from = fs.String("from", "", "Format of the input: yaml, json or toml (default from its extension, yaml for stdin)")
+	var to = fs.String("to", "", "Format of the output: yaml, json or toml (default from the extension of -o)")
+	var output = fs.String("o", "", "File to write the converted configuration to (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: home-ddns config convert [-from yaml] -to toml [config.yaml]\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return flagExitCode(err)
+	}
+	input, err := config.ParseFormat(*from)
+	if err == nil && input == "" {
+		input = config.FormatOf(fs.Arg(0))
+	}
+	if err != nil {
+		return commandError(err)
+	}
+	target, err := config.ParseFormat(*to)
+	if err != nil {
+		return commandError(err)
+	}
+	if target == "" {
+		if *output == "" {
+			return commandError(errors.New("-to is required when writing to stdout"))
+		}
+		target = config.FormatOf(*output)
+	}
+	data, err := readInput(fs)
+	if err != nil {
+		return commandError(err)
+	}
+	converted, err := config.Convert(data, input, target)
+	if err != nil {
+		return commandError(err)
+	}
+	if err := writeOutput(*output, converted); err != nil {
+		return commandError(err)
+	}
+	return exitSuccess
+}
+
+// configCommand dispatches the `home-ddns config` subcommands
+func configCommand(args []string) int {
+	commands := map[string]func([]string) int{
+		"encrypt": configEncryptCommand,
+		"decrypt": configDecryptCommand,
+		"lint":    configLintCommand,
+		"init":    configInitCommand,
+		"show":    configShowCommand,
+		"convert": configConvertCommand,
+	}
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			return command(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: home-ddns config init|lint|show|convert|encrypt|decrypt [flags]")
+	return exitConfigError
+}
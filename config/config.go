@@ -3,63 +3,260 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/facts"
+	"github.com/sudneo/home-ddns/history"
 	"github.com/sudneo/home-ddns/models"
-	yaml "gopkg.in/yaml.v3"
+	"github.com/sudneo/home-ddns/notify"
+	"github.com/sudneo/home-ddns/propagation"
+	"github.com/sudneo/home-ddns/schedule"
 )
 
 type InvalidConfiguration struct {
 	Description string
+	// Every problem found, with its position in the file
+	Problems []Problem
 }
 
 func (i *InvalidConfiguration) Error() string {
-	return fmt.Sprintf("Invalid configuration: %s", i.Description)
+	if len(i.Problems) == 0 {
+		return fmt.Sprintf("Invalid configuration: %s", i.Description)
+	}
+	lines := make([]string, len(i.Problems))
+	for n, problem := range i.Problems {
+		lines[n] = "  " + problem.String()
+	}
+	return fmt.Sprintf("Invalid configuration, %s:\n%s", i.Description, strings.Join(lines, "\n"))
 }
 
+const (
+	defaultCallTimeout = 30 * time.Second
+	defaultRunTimeout  = 10 * time.Minute
+)
+
 type Config struct {
 	Providers []ProviderConfiguration `yaml:"providers"`
+	Timeouts  TimeoutConfiguration    `yaml:"timeouts"`
+	// Default schedule of cron mode, overridden by providers and domains
+	Schedule ScheduleConfiguration `yaml:"schedule"`
+	// Destinations notified of IP changes, updates and failures
+	Notifications []notify.Configuration `yaml:"notifications"`
+	// Store keeping track of IP changes and record mutations
+	History history.Configuration `yaml:"history"`
+	// Verification of changes against the authoritative nameservers
+	Propagation propagation.Configuration `yaml:"propagation"`
+	// Values of the records which do not set them, overridden by the providers and domains defaults
+	Defaults RecordDefaults `yaml:"defaults"`
+	// Named sources of the addresses of A and AAAA records, e.g. a VPN interface
+	IPSources map[string]facts.Source `yaml:"ip_sources"`
+	// Other configuration files to read, as paths or glob patterns relative to this file
+	Include []string `yaml:"include"`
+	// Files the configuration was read from
+	files []string
+	// Patterns of the files which would be part of the configuration if created
+	patterns []string
+}
+
+// Files returns the paths of the files the configuration was read from
+func (c Config) Files() []string {
+	return append([]string(nil), c.files...)
+}
+
+// When records are processed in cron mode
+type ScheduleConfiguration struct {
+	// Cron expression with 5 or 6 fields, or a descriptor such as @hourly or @every 90s
+	Cron string `yaml:"cron"`
+	// Maximum random delay added to every execution
+	Jitter time.Duration `yaml:"jitter"`
+}
+
+// Deadlines applied to the calls made towards third parties
+type TimeoutConfiguration struct {
+	// Maximum duration of a single API call
+	Call time.Duration `yaml:"call"`
+	// Maximum duration of a whole execution, across all providers
+	Run time.Duration `yaml:"run"`
 }
 
 type ProviderConfiguration struct {
+	// Optional identifier of the account, defaults to the provider name
+	ID        string                `yaml:"id"`
 	Name      string                `yaml:"name"`
 	Domains   []DomainConfiguration `yaml:"domains"`
 	ClientID  string                `yaml:"client_id"`
 	ClientKey string                `yaml:"client_key"`
+	// Alternative sources of the credentials: a file, e.g. a Docker or Kubernetes secret, or an environment variable
+	ClientIDFile  string `yaml:"client_id_file"`
+	ClientIDEnv   string `yaml:"client_id_env"`
+	ClientKeyFile string `yaml:"client_key_file"`
+	ClientKeyEnv  string `yaml:"client_key_env"`
+	// Retry policy for the API calls of this provider, unset values take the defaults
+	Retry api.RetryPolicy `yaml:"retry"`
+	// Optional schedule of the domains of this provider
+	Schedule *ScheduleConfiguration `yaml:"schedule"`
+	// Values of the records of this provider which do not set them, overridden by the domains defaults
+	Defaults RecordDefaults `yaml:"defaults"`
 }
 
 type DomainConfiguration struct {
 	Domain  string             `yaml:"domain"`
 	Records []models.DNSRecord `yaml:"records"`
+	// Optional schedule of the records of this domain
+	Schedule *ScheduleConfiguration `yaml:"schedule"`
+	// Values of the records of this domain which do not set them
+	Defaults RecordDefaults `yaml:"defaults"`
+}
+
+func (s ScheduleConfiguration) String() string {
+	if s.Jitter > 0 {
+		return fmt.Sprintf("%s (jitter %s)", s.Cron, s.Jitter)
+	}
+	return s.Cron
 }
 
+// Parse validates the schedule
+func (s ScheduleConfiguration) Parse() (*schedule.Schedule, error) {
+	return schedule.Parse(s.Cron, s.Jitter)
+}
+
+// DomainSchedule returns the schedule of a domain: its own, otherwise the one of its provider, otherwise the global one
+func (c Config) DomainSchedule(provider ProviderConfiguration, domain DomainConfiguration) ScheduleConfiguration {
+	if domain.Schedule != nil {
+		return *domain.Schedule
+	}
+	if provider.Schedule != nil {
+		return *provider.Schedule
+	}
+	return c.Schedule
+}
+
+// ReadConfig reads and validates the configuration from a file, with the files it includes,
+// or from every configuration file of a directory. The format is detected from the extensions
 func ReadConfig(configFile string) (Config, error) {
+	return ReadConfigFormat(configFile, "")
+}
+
+// ReadConfigFormat reads the configuration like ReadConfig, with an explicit format for the file
+// or the files of the directory. Included files are still detected from their extension
+func ReadConfigFormat(configFile string, format Format) (Config, error) {
+	config, _, err := readConfig(configFile, format)
+	return config, err
+}
+
+// readConfig reads, decrypts and validates a configuration file or directory. The validator
+// is returned to locate further findings, it is nil when the configuration could not be read
+func readConfig(configFile string, format Format) (Config, *validator, error) {
 	var config Config
+	l, err := load(configFile, format)
+	if err != nil {
+		return config, nil, err
+	}
+	config, v, err := l.build()
+	config.files = append(l.files, config.files...)
+	config.patterns = l.patterns
+	return config, v, err
+}
+
+// load reads and decrypts a configuration file or directory with the files it includes, without checking it
+func load(configFile string, format Format) (*loader, error) {
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not read file %s", configFile)}
+	}
+	l := newLoader(configFile, format)
+	if info.IsDir() {
+		if err := l.readDir(configFile); err != nil {
+			return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not read directory %s: %s", configFile, err)}
+		}
+		return l, nil
+	}
 	yamlFile, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		return config, &InvalidConfiguration{Description: fmt.Sprintf("Could not read file %s", configFile)}
+		return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not read file %s", configFile)}
 	}
-	config, err = parseConfig(yamlFile)
-	return config, err
+	yamlFile, err = Decrypt(yamlFile)
+	if err != nil {
+		return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not decrypt file %s: %s", configFile, err)}
+	}
+	l.files = append(l.files, configFile)
+	l.add(configFile, yamlFile, l.formatOf(configFile, false))
+	return l, nil
 }
 
-func parseConfig(yamlData []byte) (Config, error) {
-	var config Config
-	err := yaml.Unmarshal(yamlData, &config)
+// ReadHistory reads the history section of a configuration only, so that the history can be queried
+// without the credentials and the rest of the configuration being valid
+func ReadHistory(configFile string) (history.Configuration, error) {
+	var conf history.Configuration
+	l, err := load(configFile, "")
 	if err != nil {
-		return config, &InvalidConfiguration{Description: "Invalid YAML in configuration"}
+		return conf, err
+	}
+	v := l.v
+	if l.broken {
+		return conf, v.err()
+	}
+	root := l.merge()
+	// Problems of the other sections do not matter
+	v.problems = nil
+	node := mappingValue(root, "history")
+	if node == nil {
+		return conf, nil
 	}
-	if len(config.Providers) == 0 {
-		return config, &InvalidConfiguration{Description: "No provider configuration supplied"}
+	if err := node.Decode(&conf); err != nil {
+		v.addNode(node, "invalid history: %s", err)
+	} else if err := conf.Validate(); err != nil {
+		v.addNode(node, "invalid history: %s", err)
 	}
-	totalDomains := 0
-	for _, provider := range config.Providers {
-		totalDomains += len(provider.Domains)
-		if provider.ClientID == "" || provider.ClientKey == "" {
-			return config, &InvalidConfiguration{Description: "Provider configured but no API credentials supplied"}
+	conf.Path = resolvePath(filepath.Dir(v.fileOf(node)), conf.Path)
+	return conf, v.err()
+}
+
+// resolvePath makes a relative path relative to a directory, empty paths are kept
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// parseConfig decodes and validates a configuration, reporting every problem found with its position.
+// Relative paths are relative to the directory of file
+func parseConfig(yamlData []byte, file string) (Config, error) {
+	config, _, err := parse(yamlData, file)
+	return config, err
+}
+
+func parse(yamlData []byte, file string) (Config, *validator, error) {
+	l := newLoader(file, "")
+	l.add(file, yamlData, FormatOf(file))
+	return l.build()
+}
+
+// assignProviderIDs makes sure every provider account has a unique ID.
+// Accounts without an explicit ID get the provider name, suffixed with
+// a counter when the same provider is configured more than once.
+// Explicit IDs are unique already, the validator reports the ones used twice
+func assignProviderIDs(providers []ProviderConfiguration) {
+	seen := make(map[string]bool)
+	for _, provider := range providers {
+		if provider.ID != "" {
+			seen[provider.ID] = true
 		}
 	}
-	if totalDomains == 0 {
-		return config, &InvalidConfiguration{Description: "No domain configuration supplied"}
+	for i := range providers {
+		if providers[i].ID != "" {
+			continue
+		}
+		id := providers[i].Name
+		for n := 2; seen[id]; n++ {
+			id = fmt.Sprintf("%s-%d", providers[i].Name, n)
+		}
+		providers[i].ID = id
+		seen[id] = true
 	}
-	return config, nil
 }
@@ -0,0 +1,101 @@
+package config
+
+import (
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Values applied to the records which do not set them, at the top level, per provider or per domain
+type RecordDefaults struct {
+	Value    string `yaml:"value"`
+	Type     string `yaml:"type"`
+	TTL      int    `yaml:"ttl"`
+	Weight   *int   `yaml:"weight"`
+	Service  string `yaml:"service"`
+	Protocol string `yaml:"protocol"`
+	Priority *int   `yaml:"priority"`
+	Port     int    `yaml:"port"`
+	Source   string `yaml:"source"`
+}
+
+// mappingEntry returns the key and value nodes of a key of a mapping node, or nil
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil {
+		return nil, nil
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// mappingValue returns the value of a key of a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	_, value := mappingEntry(node, key)
+	return value
+}
+
+// mergeDefaults returns a copy of a record with the keys of the defaults it does not set.
+// Defaults are given from the most to the least specific one
+func mergeDefaults(record *yaml.Node, defaults []*yaml.Node) *yaml.Node {
+	if record.Kind == yaml.AliasNode {
+		record = record.Alias
+	}
+	if record.Kind != yaml.MappingNode {
+		return record
+	}
+	merged := *record
+	merged.Content = append([]*yaml.Node(nil), record.Content...)
+	for _, d := range defaults {
+		if d == nil {
+			continue
+		}
+		if d.Kind == yaml.AliasNode {
+			d = d.Alias
+		}
+		if d.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(d.Content); i += 2 {
+			if mappingValue(&merged, d.Content[i].Value) == nil {
+				merged.Content = append(merged.Content, d.Content[i], d.Content[i+1])
+			}
+		}
+	}
+	return &merged
+}
+
+// applyDefaults merges the defaults blocks into the records of a document before it is decoded,
+// so that explicit record fields always win, followed by the domain, provider and top level defaults.
+// Problems in a default value are reported at its position in the defaults block
+func applyDefaults(root *yaml.Node) {
+	global := mappingValue(root, "defaults")
+	providers := mappingValue(root, "providers")
+	if providers == nil || providers.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, provider := range providers.Content {
+		providerDefaults := mappingValue(provider, "defaults")
+		domains := mappingValue(provider, "domains")
+		if domains == nil || domains.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, domain := range domains.Content {
+			records := mappingValue(domain, "records")
+			if records == nil || records.Kind != yaml.SequenceNode {
+				continue
+			}
+			defaults := []*yaml.Node{mappingValue(domain, "defaults"), providerDefaults, global}
+			for i, record := range records.Content {
+				records.Content[i] = mergeDefaults(record, defaults)
+			}
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Environment variables holding the age identities used to decrypt configurations,
+// either directly or in a file. The SOPS variables are honored as well
+var (
+	ageKeyEnvs     = []string{"HOME_DDNS_AGE_KEY", "SOPS_AGE_KEY"}
+	ageKeyFileEnvs = []string{"HOME_DDNS_AGE_KEY_FILE", "SOPS_AGE_KEY_FILE"}
+)
+
+const (
+	ageHeader = "age-encryption.org/v1"
+	// Key of the metadata of sops files
+	sopsKey = "sops"
+)
+
+// Values encrypted by sops, e.g. ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:([a-z]+)\]$`)
+
+// ErrNoIdentity is returned when an encrypted configuration is found but no key is available
+var ErrNoIdentity = errors.New("configuration is encrypted but no age key is set, use HOME_DDNS_AGE_KEY or HOME_DDNS_AGE_KEY_FILE")
+
+// loadIdentities reads the age identities from the environment
+func loadIdentities() ([]age.Identity, error) {
+	for _, env := range ageKeyEnvs {
+		if key := os.Getenv(env); key != "" {
+			return age.ParseIdentities(strings.NewReader(key))
+		}
+	}
+	for _, env := range ageKeyFileEnvs {
+		if path := os.Getenv(env); path != "" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return age.ParseIdentities(f)
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+func isAge(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte(ageHeader)) || bytes.HasPrefix(trimmed, []byte(armor.Header))
+}
+
+// ageDecrypt decrypts a binary or armored age file
+func ageDecrypt(data []byte, identities []age.Identity) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		reader = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	plain, err := age.Decrypt(reader, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(plain)
+}
+
+// sopsMetadata returns the sops metadata of a YAML document, if any
+func sopsMetadata(document *yaml.Node) *yaml.Node {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == sopsKey && root.Content[i+1].Kind == yaml.MappingNode {
+			return root.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sopsDataKey decrypts the data key of a sops file with one of the age identities
+func sopsDataKey(metadata *yaml.Node, identities []age.Identity) ([]byte, error) {
+	var meta struct {
+		Age []struct {
+			Recipient string `yaml:"recipient"`
+			Enc       string `yaml:"enc"`
+		} `yaml:"age"`
+	}
+	if err := metadata.Decode(&meta); err != nil {
+		return nil, err
+	}
+	if len(meta.Age) == 0 {
+		return nil, errors.New("sops file is not encrypted with age")
+	}
+	var lastErr error
+	for _, recipient := range meta.Age {
+		key, err := ageDecrypt([]byte(recipient.Enc), identities)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// sopsDecryptValue decrypts a value encrypted by sops, authenticated with additional data: the path of its keys
+// for the values of the document
+func sopsDecryptValue(value string, key []byte, additionalData string) (string, string, error) {
+	match := sopsValue.FindStringSubmatch(value)
+	decoded := make([][]byte, 3)
+	for i := range decoded {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(match[i+1]); err != nil {
+			return "", "", err
+		}
+	}
+	data, iv, tag := decoded[0], decoded[1], decoded[2]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", err
+	}
+	return string(plain), match[4], nil
+}
+
+// sopsDecryptNode decrypts every encrypted scalar below a node, path holds the mapping keys leading to it.
+// The decrypted nodes are recorded in encrypted
+func sopsDecryptNode(node *yaml.Node, key []byte, path []string, encrypted map[*yaml.Node]bool) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !sopsValue.MatchString(node.Value) {
+			return nil
+		}
+		plain, valueType, err := sopsDecryptValue(node.Value, key, strings.Join(path, ":")+":")
+		if err != nil {
+			return fmt.Errorf("could not decrypt %s: %s", strings.Join(path, "."), err)
+		}
+		encrypted[node] = true
+		node.Value = plain
+		node.Style = 0
+		switch valueType {
+		case "int":
+			node.Tag = "!!int"
+		case "float":
+			node.Tag = "!!float"
+		case "bool":
+			node.Tag = "!!bool"
+		default:
+			node.Tag = "!!str"
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := sopsDecryptNode(node.Content[i+1], key, append(path[:len(path):len(path)], node.Content[i].Value), encrypted); err != nil {
+				return err
+			}
+		}
+	default:
+		// Items of sequences share the path of the sequence
+		for _, child := range node.Content {
+			if err := sopsDecryptNode(child, key, path, encrypted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sopsHashNode adds the values below a node to the MAC of a sops document, in the form sops hashes them.
+// When onlyEncrypted, the values which were stored in plain text are left out
+func sopsHashNode(node *yaml.Node, hash io.Writer, encrypted map[*yaml.Node]bool, onlyEncrypted bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if onlyEncrypted && !encrypted[node] {
+			return
+		}
+		value := node.Value
+		switch node.ShortTag() {
+		case "!!bool":
+			if b, err := strconv.ParseBool(value); err == nil {
+				value = "False"
+				if b {
+					value = "True"
+				}
+			}
+		case "!!int":
+			if i, err := strconv.ParseInt(value, 0, 64); err == nil {
+				value = strconv.FormatInt(i, 10)
+			}
+		case "!!float":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				value = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		case "!!null":
+			value = ""
+		}
+		hash.Write([]byte(value))
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			sopsHashNode(node.Content[i+1], hash, encrypted, onlyEncrypted)
+		}
+	default:
+		for _, child := range node.Content {
+			sopsHashNode(child, hash, encrypted, onlyEncrypted)
+		}
+	}
+}
+
+// sopsVerifyMAC checks the MAC of a decrypted sops document, which authenticates the document as a whole,
+// including the values stored in plain text and the keys present
+func sopsVerifyMAC(root *yaml.Node, metadata *yaml.Node, key []byte, encrypted map[*yaml.Node]bool) error {
+	var meta struct {
+		LastModified     string `yaml:"lastmodified"`
+		MAC              string `yaml:"mac"`
+		MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+	}
+	if err := metadata.Decode(&meta); err != nil {
+		return err
+	}
+	if !sopsValue.MatchString(meta.MAC) {
+		return errors.New("sops file has no mac")
+	}
+	// The MAC is encrypted with the time of the last modification as additional data
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid lastmodified of sops file: %s", err)
+	}
+	expected, _, err := sopsDecryptValue(meta.MAC, key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("could not decrypt the mac of sops file: %s", err)
+	}
+	hash := sha512.New()
+	sopsHashNode(root, hash, encrypted, meta.MACOnlyEncrypted)
+	actual := fmt.Sprintf("%X", hash.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(actual), []byte(strings.ToUpper(expected))) != 1 {
+		return errors.New("sops file was modified, its mac does not match its content")
+	}
+	return nil
+}
+
+// sopsDecrypt decrypts the values of a sops YAML document, verifies its MAC and drops its metadata
+func sopsDecrypt(document *yaml.Node, identities []age.Identity) ([]byte, error) {
+	metadata := sopsMetadata(document)
+	key, err := sopsDataKey(metadata, identities)
+	if err != nil {
+		return nil, err
+	}
+	root := document.Content[0]
+	content := make([]*yaml.Node, 0, len(root.Content))
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != sopsKey {
+			content = append(content, root.Content[i], root.Content[i+1])
+		}
+	}
+	root.Content = content
+	encrypted := make(map[*yaml.Node]bool)
+	if err := sopsDecryptNode(root, key, nil, encrypted); err != nil {
+		return nil, err
+	}
+	if err := sopsVerifyMAC(root, metadata, key, encrypted); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(document)
+}
+
+// IsEncrypted returns whether data is an age encrypted file or a sops YAML document
+func IsEncrypted(data []byte) bool {
+	if isAge(data) {
+		return true
+	}
+	var document yaml.Node
+	return yaml.Unmarshal(data, &document) == nil && sopsMetadata(&document) != nil
+}
+
+// Decrypt returns the plain text of an age encrypted file or of a sops YAML document encrypted with age,
+// using the identities from the environment. Other data is returned unchanged.
+// Decryption happens in memory only
+func Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	identities, err := loadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	if isAge(data) {
+		return ageDecrypt(data, identities)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return sopsDecrypt(&document, identities)
+}
+
+// Encrypt encrypts data with age for the given recipients, armored so that it can be committed as text
+func Encrypt(data []byte, recipients []age.Recipient) ([]byte, error) {
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func testIdentity(t *testing.T) *age.X25519Identity {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOME_DDNS_AGE_KEY", identity.String())
+	t.Cleanup(func() { os.Unsetenv("HOME_DDNS_AGE_KEY") })
+	return identity
+}
+
+func TestAgeConfig(t *testing.T) {
+	identity := testIdentity(t)
+	encrypted, err := Encrypt(complexConfig, []age.Recipient{identity.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encrypted), "client_key") || !IsEncrypted(encrypted) {
+		t.Fatalf("Configuration not encrypted:\n%s", encrypted)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml.age")
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("Reading the encrypted configuration lead to error: %s", err)
+	}
+	if len(config.Providers) != 2 {
+		t.Errorf("Unexpected configuration %+v", config)
+	}
+
+	other, _ := age.GenerateX25519Identity()
+	os.Setenv("HOME_DDNS_AGE_KEY", other.String())
+	if _, err := ReadConfig(path); err == nil {
+		t.Errorf("Configuration decrypted with the wrong key")
+	}
+	os.Unsetenv("HOME_DDNS_AGE_KEY")
+	if _, err := ReadConfig(path); err == nil || !strings.Contains(err.Error(), "no age key") {
+		t.Errorf("Expected missing key error, got %v", err)
+	}
+}
+
+// sopsEncrypt encrypts a value the way sops does, with a 32 bytes IV and the path of keys as additional data
+func sopsEncrypt(t *testing.T, key []byte, value string, valueType string, path string) string {
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCMWithNonceSize(block, 32)
+	iv := make([]byte, 32)
+	rand.Read(iv)
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(path))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(tag), valueType)
+}
+
+func TestSopsConfig(t *testing.T) {
+	identity := testIdentity(t)
+	key := make([]byte, 32)
+	rand.Read(key)
+	encryptedKey, err := Encrypt(key, []age.Recipient{identity.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := fmt.Sprintf(`providers:
+    - name: Godaddy
+      client_id: %s
+      client_key: %s
+      domains:
+        - domain: example.com
+          records:
+            - name: home
+              type: A
+              ttl: %s
+sops:
+    age:
+        - recipient: %s
+          enc: |
+%s
+    lastmodified: "2024-01-01T00:00:00Z"
+    mac: %s
+    version: 3.8.1
+`,
+		sopsEncrypt(t, key, "my-id", "str", "providers:client_id:"),
+		sopsEncrypt(t, key, "my-key", "str", "providers:client_key:"),
+		sopsEncrypt(t, key, "3600", "int", "providers:domains:records:ttl:"),
+		identity.Recipient(),
+		"            "+strings.ReplaceAll(strings.TrimSpace(string(encryptedKey)), "\n", "\n            "),
+		// The MAC covers every value of the document, in order, and is authenticated with the last modification time
+		sopsEncrypt(t, key, sopsMAC("Godaddy", "my-id", "my-key", "example.com", "home", "A", "3600"), "str", "2024-01-01T00:00:00Z"))
+	if !IsEncrypted([]byte(document)) {
+		t.Fatalf("sops document not detected")
+	}
+	plain, err := Decrypt([]byte(document))
+	if err != nil {
+		t.Fatalf("Decrypting the sops document lead to error: %s", err)
+	}
+	if strings.Contains(string(plain), "sops") || strings.Contains(string(plain), "ENC[") {
+		t.Errorf("Metadata or encrypted values left:\n%s", plain)
+	}
+	config, err := parseConfig(plain, "")
+	if err != nil {
+		t.Fatalf("Parsing the decrypted document lead to error: %s", err)
+	}
+	provider := config.Providers[0]
+	if provider.ClientID != "my-id" || provider.ClientKey != "my-key" || provider.Domains[0].Records[0].TTL != 3600 {
+		t.Errorf("Unexpected decrypted provider %+v", provider)
+	}
+
+	// Values moved to another key do not decrypt
+	tampered := strings.Replace(document, "client_id:", "client_id_moved: x\n      client_id_unused:", 1)
+	tampered = strings.Replace(tampered, "client_key:", "client_id:", 1)
+	if _, err := Decrypt([]byte(tampered)); err == nil {
+		t.Errorf("Value moved to another key was decrypted")
+	}
+
+	// Changes to the plain text values and keys are detected by the MAC
+	for name, tampered := range map[string]string{
+		"changed value": strings.Replace(document, "name: Godaddy", "name: Porkbun", 1),
+		"added key":     strings.Replace(document, "    - name: Godaddy", "    - name: Godaddy\n      id: attacker", 1),
+		"removed key":   strings.Replace(document, "              type: A\n", "", 1),
+		"no mac":        document[:strings.Index(document, "    mac:")] + "    version: 3.8.1\n",
+	} {
+		if _, err := Decrypt([]byte(tampered)); err == nil {
+			t.Errorf("sops document with %s was accepted", name)
+		}
+	}
+}
+
+// sopsMAC returns the MAC of a sops document with the given values, as sops computes it
+func sopsMAC(values ...string) string {
+	hash := sha512.New()
+	for _, value := range values {
+		hash.Write([]byte(value))
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil))
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Format is the syntax of a configuration file
+type Format string
+
+const (
+	YAML Format = "yaml"
+	JSON Format = "json"
+	TOML Format = "toml"
+)
+
+// Formats which can be read and written
+var formats = []Format{YAML, JSON, TOML}
+
+// ParseFormat validates the name of a format, an empty name means detecting it from the file extension
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return "", nil
+	}
+	for _, format := range formats {
+		if strings.EqualFold(name, string(format)) {
+			return format, nil
+		}
+	}
+	names := make([]string, len(formats))
+	for i, format := range formats {
+		names[i] = string(format)
+	}
+	return "", fmt.Errorf("unknown format %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// FormatOf returns the format of a file from its extension, ignoring the .age extension of encrypted files.
+// Unknown extensions are read as YAML
+func FormatOf(file string) Format {
+	file = strings.TrimSuffix(file, ".age")
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return JSON
+	case ".toml":
+		return TOML
+	}
+	return YAML
+}
+
+// positionError is a syntax error at a position of a file
+type positionError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *positionError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// parseDocument parses a file in any format into the root node of a YAML document, nil when the file is empty.
+// JSON is a subset of YAML, while TOML is translated keeping the positions of its keys and values
+func parseDocument(data []byte, format Format) (*yaml.Node, error) {
+	if format == TOML {
+		return parseTOML(data)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		var syntaxErr *json.SyntaxError
+		if format == JSON && errors.As(json.Unmarshal(data, new(interface{})), &syntaxErr) {
+			// The errors of encoding/json are clearer for JSON files, and locate the problem
+			lead := data[:syntaxErr.Offset]
+			line := bytes.Count(lead, []byte("\n")) + 1
+			column := len(lead) - bytes.LastIndexByte(lead, '\n') - 1
+			return nil, &positionError{Line: line, Column: column, Message: syntaxErr.Error()}
+		}
+		return nil, err
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil, nil
+	}
+	return document.Content[0], nil
+}
+
+// writeJSON writes a node as indented JSON, keeping the order of the keys
+func writeJSON(out *bytes.Buffer, node *yaml.Node, indent string) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			out.WriteString("{}")
+			return nil
+		}
+		out.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, _ := json.Marshal(node.Content[i].Value)
+			out.WriteString(indent + "  " + string(key) + ": ")
+			if err := writeJSON(out, node.Content[i+1], indent+"  "); err != nil {
+				return err
+			}
+			if i+2 < len(node.Content) {
+				out.WriteString(",")
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			out.WriteString("[]")
+			return nil
+		}
+		out.WriteString("[\n")
+		for i, item := range node.Content {
+			out.WriteString(indent + "  ")
+			if err := writeJSON(out, item, indent+"  "); err != nil {
+				return err
+			}
+			if i+1 < len(node.Content) {
+				out.WriteString(",")
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString(indent + "]")
+	default:
+		value, err := scalarJSON(node)
+		if err != nil {
+			return err
+		}
+		out.Write(value)
+	}
+	return nil
+}
+
+// scalarJSON encodes a scalar node as JSON, according to its resolved type
+func scalarJSON(node *yaml.Node) ([]byte, error) {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, fmt.Errorf("line %d: %s", node.Line, err)
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// blockStyle clears the style of the nodes, so that JSON documents are written as block YAML
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// Convert translates a plain text configuration between formats. Comments are only kept from YAML to YAML
+func Convert(data []byte, from Format, to Format) ([]byte, error) {
+	if IsEncrypted(data) {
+		return nil, fmt.Errorf("the configuration is encrypted, decrypt it first")
+	}
+	root, err := parseDocument(data, from)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	var out bytes.Buffer
+	switch to {
+	case JSON:
+		if err := writeJSON(&out, root, ""); err != nil {
+			return nil, err
+		}
+		out.WriteString("\n")
+	case TOML:
+		if err := writeTOML(&out, root); err != nil {
+			return nil, err
+		}
+	default:
+		if from != YAML {
+			blockStyle(root)
+		}
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(root); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var tomlConfig = []byte(`
+[defaults]
+ttl = 3_600
+
+[[providers]]
+name = "Godaddy"
+client_id = "id"
+client_key = "key"
+retry.max_attempts = 2
+
+[[providers.domains]]
+domain = "example.com"
+records = [
+  { name = "test", type = "A", ttl = 70 },
+  { name = "ctest", type = "CNAME" },
+]
+
+[[providers]]
+name = "Porkbun"
+client_id = "id"
+client_key = "key"
+
+[[providers.domains]]
+domain = "test.com"
+
+[[providers.domains.records]]
+name = "home"
+type = "A"
+
+[[providers.domains.records]]
+name = "vpn"
+type = "CNAME"
+value = "home"
+
+[timeouts]
+call = "5s"
+`)
+
+var jsonConfig = []byte(`{
+	"defaults": {"ttl": 3600},
+	"providers": [
+		{
+			"name": "Godaddy",
+			"client_id": "id",
+			"client_key": "key",
+			"retry": {"max_attempts": 2},
+			"domains": [
+				{
+					"domain": "example.com",
+					"records": [
+						{"name": "test", "type": "A", "ttl": 70},
+						{"name": "ctest", "type": "CNAME"}
+					]
+				}
+			]
+		},
+		{
+			"name": "Porkbun",
+			"client_id": "id",
+			"client_key": "key",
+			"domains": [
+				{
+					"domain": "test.com",
+					"records": [
+						{"name": "home", "type": "A"},
+						{"name": "vpn", "type": "CNAME", "value": "home"}
+					]
+				}
+			]
+		}
+	],
+	"timeouts": {"call": "5s"}
+}`)
+
+func TestFormats(t *testing.T) {
+	fromTOML, err := parseConfig(tomlConfig, "config.toml")
+	if err != nil {
+		t.Fatalf("Parsing the TOML configuration lead to error: %s", err)
+	}
+	fromJSON, err := parseConfig(jsonConfig, "config.json")
+	if err != nil {
+		t.Fatalf("Parsing the JSON configuration lead to error: %s", err)
+	}
+	if !reflect.DeepEqual(fromTOML, fromJSON) {
+		t.Errorf("TOML and JSON configurations differ:\n%+v\n%+v", fromTOML, fromJSON)
+	}
+	if record := fromTOML.Providers[0].Domains[0].Records[1]; record.Name != "ctest" || record.TTL != 3600 {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if fromTOML.Providers[0].Retry.MaxAttempts != 2 || fromTOML.Timeouts.Call.Seconds() != 5 {
+		t.Errorf("Unexpected settings %+v %+v", fromTOML.Providers[0].Retry, fromTOML.Timeouts)
+	}
+
+	// Problems are located in every format
+	invalid := map[string]string{
+		"config.toml:3:1: unknown field \"nmae\" in providers[0], did you mean \"name\"?": `
+[[providers]]
+nmae = "Godaddy"
+`,
+		"config.toml:7:9: value \"home\" of A record is not an IPv4 address": `
+[[providers]]
+name = "Godaddy"
+[[providers.domains]]
+domain = "example.com"
+[[providers.domains.records]]
+value = "home"
+type = "A"
+name = "home"
+`,
+		"config.toml:3:1: key name is already defined": `
+name = "Godaddy"
+name = "Porkbun"
+`,
+		"config.toml:2:11: expected character ]": `
+[providers
+`,
+		"config.json:1:17: unknown field \"nmae\" in providers[0], did you mean \"name\"?": `{"providers": [{"nmae": "Godaddy"}]}`,
+		"config.json:1:16: invalid character '}' looking for beginning of value":           `{"providers": [}`,
+	}
+	for expected, content := range invalid {
+		file := expected[:strings.Index(expected, ":")]
+		_, err := parseConfig([]byte(content), file)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestReadConfigFormat(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, tomlConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(path); err == nil {
+		t.Errorf("TOML configuration without extension read as YAML")
+	}
+	if _, err := ReadConfigFormat(path, TOML); err != nil {
+		t.Errorf("Reading the TOML configuration with an explicit format lead to error: %s", err)
+	}
+	if _, err := ParseFormat("ini"); err == nil {
+		t.Errorf("Unknown format accepted")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	expected, err := parseConfig(complexConfig, "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, format := complexConfig, YAML
+	for _, target := range []Format{TOML, JSON, YAML, JSON, TOML, YAML} {
+		converted, err := Convert(data, format, target)
+		if err != nil {
+			t.Fatalf("Converting from %s to %s lead to error: %s", format, target, err)
+		}
+		config, err := parseConfig(converted, "config."+string(target))
+		if err != nil {
+			t.Fatalf("Parsing the configuration converted to %s lead to error: %s\n%s", target, err, converted)
+		}
+		if !reflect.DeepEqual(config, expected) {
+			t.Errorf("Configuration converted to %s differs:\n%s", target, converted)
+		}
+		data, format = converted, target
+	}
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Extensions of the files read from a configuration directory
+var configExtensions = []string{".yaml", ".yml", ".json", ".toml", ".age"}
+
+// loader reads the files of a configuration, following their includes, and merges them in a single document
+type loader struct {
+	v *validator
+	// Format of the files given explicitly, included files are detected from their extension
+	format Format
+	// Root of each file, in the order they were read
+	roots []*yaml.Node
+	// Files read, and patterns of the files which would be read if created
+	files    []string
+	patterns []string
+	seen     map[string]bool
+	// Whether a file could not be parsed, the configuration is not checked any further
+	broken bool
+	// Whether a file could not be decoded, the merged document would fail in the same way
+	decodeFailed bool
+}
+
+func newLoader(file string, format Format) *loader {
+	return &loader{v: newValidator(file), format: format, seen: map[string]bool{filepath.Clean(file): true}}
+}
+
+// formatOf returns the format of a file, include tells whether it was included by another file
+func (l *loader) formatOf(file string, include bool) Format {
+	if l.format != "" && !include {
+		return l.format
+	}
+	return FormatOf(file)
+}
+
+// hasMeta reports whether a path contains glob characters
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// configFiles lists the configuration files of a directory, by name. Hidden files are skipped,
+// such as the ..data entries of Kubernetes volumes
+func configFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		for _, extension := range configExtensions {
+			if filepath.Ext(name) != extension {
+				continue
+			}
+			// Entries are often symlinks, e.g. in Kubernetes volumes
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Mode().IsRegular() {
+				files = append(files, filepath.Join(dir, name))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readDir reads every configuration file of a directory
+func (l *loader) readDir(dir string) error {
+	files, err := configFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no file with extension %s found", strings.Join(configExtensions, ", "))
+	}
+	for _, extension := range configExtensions {
+		l.patterns = append(l.patterns, filepath.Join(dir, "*"+extension))
+	}
+	for _, file := range files {
+		l.readFile(file, nil)
+	}
+	return nil
+}
+
+// readFile reads an included file, problems are reported at the include entry, if any
+func (l *loader) readFile(file string, include *yaml.Node) {
+	if l.seen[filepath.Clean(file)] {
+		return
+	}
+	l.seen[filepath.Clean(file)] = true
+	fail := func(format string, args ...interface{}) {
+		if include != nil {
+			l.v.addNode(include, format, args...)
+			return
+		}
+		l.v.problems = append(l.v.problems, Problem{File: file, Message: fmt.Sprintf(format, args...)})
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fail("could not read file %s: %s", file, err)
+		return
+	}
+	data, err = Decrypt(data)
+	if err != nil {
+		fail("could not decrypt file %s: %s", file, err)
+		return
+	}
+	l.files = append(l.files, file)
+	l.add(file, data, l.formatOf(file, include != nil))
+}
+
+// add parses a file and checks it on its own, then reads the files it includes
+func (l *loader) add(file string, data []byte, format Format) {
+	root, err := parseDocument(data, format)
+	if err != nil {
+		l.v.addYAMLError(file, err)
+		l.broken = true
+		return
+	}
+	if root == nil {
+		// Empty files are allowed, e.g. a placeholder in a directory
+		return
+	}
+	l.v.setOrigin(root, file)
+	// Environment variables are expanded before decoding, so that they can be used for any value
+	interpolate(root, l.v)
+	l.v.checkFields(root, reflect.TypeOf(Config{}), "")
+	// Files are decoded on their own first, as the errors of yaml.v3 do not tell the file
+	var config Config
+	if err := root.Decode(&config); err != nil {
+		l.v.addYAMLError(file, err)
+		l.decodeFailed = true
+	}
+	l.roots = append(l.roots, root)
+	includes := mappingValue(root, "include")
+	if includes == nil {
+		return
+	}
+	entries := []*yaml.Node{includes}
+	if includes.Kind == yaml.SequenceNode {
+		entries = includes.Content
+	}
+	for _, entry := range entries {
+		if entry.Kind != yaml.ScalarNode {
+			continue
+		}
+		pattern := entry.Value
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			l.v.addNode(entry, "invalid include pattern %q: %s", entry.Value, err)
+			continue
+		}
+		if !hasMeta(pattern) {
+			if len(matches) == 0 {
+				l.v.addNode(entry, "included file %s does not exist", entry.Value)
+				continue
+			}
+		} else {
+			l.patterns = append(l.patterns, pattern)
+		}
+		for _, match := range matches {
+			l.readFile(match, entry)
+		}
+	}
+}
+
+// mergeProvider adds the domains of an account defined again in another file with the same id.
+// Other settings of the account can be set in one of the files only
+func (l *loader) mergeProvider(base *yaml.Node, other *yaml.Node) {
+	for i := 0; i+1 < len(other.Content); i += 2 {
+		key, value := other.Content[i], other.Content[i+1]
+		existingKey, existing := mappingEntry(base, key.Value)
+		switch {
+		case key.Value == "id":
+		case existing == nil:
+			base.Content = append(base.Content, key, value)
+		case key.Value == "domains" && existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			l.v.addNode(key, "%s of provider %s is already set at %s", key.Value, mappingValue(base, "id").Value, l.v.position(existingKey))
+		}
+	}
+}
+
+// merge combines the files in a single document. Providers and notifications are concatenated,
+// accounts with the same id in different files are merged, and other settings can be set once only
+func (l *loader) merge() *yaml.Node {
+	if len(l.roots) == 1 {
+		return l.roots[0]
+	}
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	keys := make(map[string]*yaml.Node)
+	sequences := make(map[string]*yaml.Node)
+	accounts := make(map[string]*yaml.Node)
+	for _, root := range l.roots {
+		if root.Kind != yaml.MappingNode {
+			// Reported when the file is decoded
+			continue
+		}
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			switch {
+			case key.Value == "include":
+			case (key.Value == "providers" || key.Value == "notifications") && value.Kind == yaml.SequenceNode:
+				sequence, ok := sequences[key.Value]
+				if !ok {
+					sequence = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: value.Line, Column: value.Column}
+					l.v.origins[sequence] = l.v.fileOf(value)
+					sequences[key.Value] = sequence
+					merged.Content = append(merged.Content, key, sequence)
+				}
+				for _, item := range value.Content {
+					if key.Value == "providers" {
+						if id := mappingValue(item, "id"); id != nil && id.Value != "" {
+							if base, ok := accounts[id.Value]; ok && l.v.fileOf(base) != l.v.fileOf(item) {
+								l.mergeProvider(base, item)
+								continue
+							} else if !ok {
+								accounts[id.Value] = item
+							}
+						}
+					}
+					sequence.Content = append(sequence.Content, item)
+				}
+			case keys[key.Value] != nil:
+				l.v.addNode(key, "%s is already set at %s", key.Value, l.v.position(keys[key.Value]))
+			default:
+				keys[key.Value] = key
+				merged.Content = append(merged.Content, key, value)
+			}
+		}
+	}
+	return merged
+}
+
+// build merges the files read and validates the resulting configuration
+func (l *loader) build() (Config, *validator, error) {
+	var config Config
+	v := l.v
+	if l.broken {
+		return config, v, v.err()
+	}
+	if len(l.roots) == 0 {
+		if len(v.problems) == 0 {
+			v.addf("", "no provider configuration supplied")
+		}
+		return config, v, v.err()
+	}
+	root := l.merge()
+	applyDefaults(root)
+	// Records copied with their defaults belong to the file of their domain
+	v.setOrigin(root, v.fileOf(root))
+	v.index(root, "")
+	if err := root.Decode(&config); err != nil && !l.decodeFailed {
+		v.addYAMLError(v.file, err)
+	}
+	v.validate(&config)
+	if err := v.err(); err != nil {
+		return config, v, err
+	}
+	assignProviderIDs(config.Providers)
+	return config, v, nil
+}
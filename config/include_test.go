@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var mainConfig = `
+include:
+  - teams/*.yaml
+timeouts:
+  call: 5s
+providers:
+  - id: main
+    name: Godaddy
+    client_id: id
+    client_key_file: secrets/key
+    domains:
+      - domain: example.com
+        records:
+          - name: home
+            type: A
+`
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": mainConfig,
+		"secrets/key": "key",
+		"teams/web.yaml": `
+providers:
+  - id: main
+    domains:
+      - domain: example.com
+        records:
+          - name: www
+            type: CNAME
+`,
+		"teams/mail.yaml": `
+providers:
+  - name: Porkbun
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.org
+        records:
+          - name: mail
+            type: MX
+            value: mail.example.org
+            priority: 10
+`,
+	})
+	path := filepath.Join(dir, "config.yaml")
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("Reading the configuration with includes lead to error: %s", err)
+	}
+	if len(config.Providers) != 2 {
+		t.Fatalf("Expected 2 providers, found %d", len(config.Providers))
+	}
+	main := config.Providers[0]
+	if main.ClientKey != "key" || len(main.Domains) != 2 || main.Domains[1].Records[0].Name != "www" {
+		t.Errorf("Account not merged across files: %+v", main)
+	}
+	if config.Providers[1].Name != "Porkbun" {
+		t.Errorf("Provider of the included file not read: %+v", config.Providers[1])
+	}
+	expected := []string{path, filepath.Join(dir, "teams/mail.yaml"), filepath.Join(dir, "teams/web.yaml"), filepath.Join(dir, "secrets/key")}
+	files := config.Files()
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected files %v, found %v", expected, files)
+	}
+
+	invalid := map[string]string{
+		// The same record defined by two teams
+		"teams/web.yaml:9:13: A record home of example.com is already defined at " + path + ":14:13": `
+providers:
+  - id: main
+    domains:
+      - domain: example.com
+        records:
+          - name: www
+            type: CNAME
+          - name: home
+            type: A
+`,
+		"teams/web.yaml:2:1: timeouts is already set at " + path + ":4:1": `
+timeouts:
+  run: 1m
+`,
+		"teams/web.yaml:4:5: client_id of provider main is already set at " + path + ":9:5": `
+providers:
+  - id: main
+    client_id: other
+`,
+		"teams/web.yaml:8:20: value \"home\" of A record is not an IPv4 address": `
+providers:
+  - id: main
+    domains:
+      - domain: example.com
+        records:
+          - name: www
+            value: home
+            type: A
+`,
+	}
+	for expected, content := range invalid {
+		writeFiles(t, dir, map[string]string{"teams/web.yaml": content})
+		_, err := ReadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, expected)) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"config.yaml": "include: [missing.yaml]\n" + mainConfig[len("\ninclude:\n  - teams/*.yaml\n"):]})
+	if _, err := ReadConfig(path); err == nil || !strings.Contains(err.Error(), "included file missing.yaml does not exist") {
+		t.Errorf("Missing included file not reported, got %v", err)
+	}
+}
+
+func TestConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"00-global.yaml": "timeouts:\n  run: 1m\n",
+		"godaddy.yaml":   string(validConfig),
+		"porkbun.yml":    "providers:\n" + string(complexConfig[strings.Index(string(complexConfig), "  - name: Porkbun"):]),
+		"README.md":      "not a configuration file",
+		".hidden.yaml":   "not: valid",
+	})
+	config, err := ReadConfig(dir)
+	if err != nil {
+		t.Fatalf("Reading the configuration directory lead to error: %s", err)
+	}
+	if len(config.Providers) != 2 || config.Providers[1].ID != "Porkbun" || config.Timeouts.Run.Minutes() != 1 {
+		t.Errorf("Configuration directory not merged: %+v", config)
+	}
+	if len(config.Files()) != 3 {
+		t.Errorf("Expected 3 files, found %v", config.Files())
+	}
+	if _, err := ReadConfig(t.TempDir()); err == nil {
+		t.Errorf("Empty configuration directory did not error")
+	}
+
+	// The same record in two files, by accounts without id
+	dir = t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.yaml": string(validConfig),
+		"b.yaml": string(validConfig),
+	})
+	_, err = ReadConfig(dir)
+	expected := filepath.Join(dir, "b.yaml") + ":9:13: A record test of example.com is already defined at " + filepath.Join(dir, "a.yaml") + ":9:13"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/rdata"
+)
+
+// StarterOptions describes the configuration generated by Starter
+type StarterOptions struct {
+	Provider string
+	Domain   string
+	// Names of the A records pointing to the public IP
+	Records []string
+}
+
+var starterTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"lower": strings.ToLower}).Parse(`# home-ddns configuration, see https://github.com/Sudneo/Home-ddns for every option
+providers:
+  - name: "{{.Provider}}" # One of {{.Providers}}
+    # Optional, tells apart multiple accounts of the same provider
+    # id: "personal"
+    # Credentials can be given inline, or read from a file or an environment variable:
+    #   client_id_file: /run/secrets/{{.EnvPrefix | lower}}_client_id
+    #   client_id_env: {{.EnvPrefix}}_CLIENT_ID
+    # Values can also reference environment variables, e.g. "${{"{"}}{{.EnvPrefix}}_CLIENT_KEY}"
+    client_id: "CHANGE_ME"
+    client_key: "CHANGE_ME"
+    domains:
+      - domain: "{{.Domain}}"
+        records:
+{{- range .Records}}
+          - name: "{{.}}"
+            type: "A" # Without a value, A and AAAA records point to the public IP
+{{- if $.MinTTL}}
+            ttl: {{$.MinTTL}} # {{$.Provider}} does not accept lower TTLs
+{{- end}}
+{{- end}}
+          # Records can also have an explicit value:
+          # - name: "www"
+          #   type: "CNAME"
+          #   value: "{{.Domain}}"
+
+# Deadlines of the calls towards third parties
+# timeouts:
+#   call: 30s
+#   run: 10m
+
+# Schedule of cron mode, by default every -interval minutes
+# schedule:
+#   cron: "*/15 * * * *"
+#   jitter: 30s
+`))
+
+// Starter generates a commented configuration for a provider and domain, to be completed with the credentials
+func Starter(options StarterOptions) ([]byte, error) {
+	if !api.IsRegistered(options.Provider) {
+		return nil, fmt.Errorf("unknown provider %q, expected one of %s", options.Provider, strings.Join(api.Providers(), ", "))
+	}
+	if !rdata.ValidHostname(options.Domain) {
+		return nil, fmt.Errorf("invalid domain %q", options.Domain)
+	}
+	if len(options.Records) == 0 {
+		options.Records = []string{"@"}
+	}
+	for _, name := range options.Records {
+		if !validRecordName(name) {
+			return nil, fmt.Errorf("invalid record name %q", name)
+		}
+	}
+	var b bytes.Buffer
+	err := starterTemplate.Execute(&b, struct {
+		StarterOptions
+		Providers string
+		EnvPrefix string
+		MinTTL    int
+	}{options, strings.Join(api.Providers(), ", "), strings.ToUpper(options.Provider), api.ProviderCapabilities(options.Provider).MinTTL})
+	return b.Bytes(), err
+}
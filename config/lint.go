@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/facts"
+)
+
+// lint finds the values of a valid configuration which will not be applied as written
+func (v *validator) lint(config Config) []Problem {
+	errors := v.problems
+	v.problems = nil
+	// Sources referenced by the records, directly or from a template
+	used := make(map[string]bool)
+	// A domain may be listed more than once, e.g. with different schedules, or by several accounts
+	seen := make(map[string]bool)
+	for i, provider := range config.Providers {
+		capabilities := api.ProviderCapabilities(provider.Name)
+		for j, domain := range provider.Domains {
+			for k, record := range domain.Records {
+				path := fmt.Sprintf("providers[%d].domains[%d].records[%d]", i, j, k)
+				key := strings.ToLower(strings.Join([]string{provider.Name, domain.Domain, record.Type, record.Name}, " "))
+				if seen[key] {
+					v.addf(path, "%s record %s is configured more than once in %s, only one of them will be applied", record.Type, record.Name, domain.Domain)
+				}
+				seen[key] = true
+				used[record.Source] = true
+				if facts.IsTemplate(record.Value) {
+					for name := range config.IPSources {
+						if strings.Contains(record.Value, fmt.Sprintf("%q", name)) {
+							used[name] = true
+						}
+					}
+				}
+				if ttl := capabilities.ClampTTL(record.TTL); ttl < record.TTL {
+					v.addf(joinPath(path, "ttl"), "ttl %d is above the maximum of %s, %d will be used", record.TTL, provider.Name, ttl)
+				} else if ttl > record.TTL {
+					v.addf(joinPath(path, "ttl"), "ttl %d is below the minimum of %s, %d will be used", record.TTL, provider.Name, ttl)
+				}
+				if record.Type == "CNAME" && record.Value == "" {
+					v.addf(path, "value not set, the CNAME record will point to %s itself", domain.Domain)
+				}
+			}
+		}
+	}
+	for _, name := range facts.SourceNames(config.IPSources) {
+		if !used[name] {
+			v.addf(joinPath("ip_sources", name), "IP source %s is not used by any record", name)
+		}
+	}
+	warnings := v.problems
+	v.problems = errors
+	sortProblems(warnings)
+	return warnings
+}
+
+// Lint reads and validates a configuration file like ReadConfigFormat, and additionally returns warnings
+// about values which will be silently changed, e.g. TTLs below the minimum of the provider
+func Lint(configFile string, format Format) (Config, []Problem, error) {
+	config, v, err := readConfig(configFile, format)
+	if err != nil {
+		return config, nil, err
+	}
+	return config, v.lint(config), nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`providers:
+  - name: Porkbun
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: home
+            type: A
+            ttl: 600
+          - name: home
+            type: A
+          - name: www
+            type: CNAME
+  - name: Godaddy
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.org
+        records:
+          - name: home
+            type: A
+            ttl: 700000
+            value: '{{.SourceIPv4 "lan"}}'
+          - name: "@"
+            type: MX
+            value: mail.example.org
+            priority: 10
+ip_sources:
+  vpn:
+    interface: wg0
+  lan:
+    type: interface
+    interface: eth0
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, warnings, err := Lint(path, "")
+	if err != nil {
+		t.Fatalf("Linting a valid configuration lead to error: %s", err)
+	}
+	expected := []string{
+		path + ":10:18: ttl 600 is below the minimum of Porkbun, 3600 will be used",
+		path + ":11:13: A record home is configured more than once in example.com",
+		path + ":13:13: value not set, the CNAME record will point to example.com itself",
+		path + ":23:18: ttl 700000 is above the maximum of Godaddy, 604800 will be used",
+		path + ":31:5: IP source vpn is not used by any record",
+	}
+	if len(warnings) != len(expected) {
+		t.Errorf("Expected %d warnings, got %v", len(expected), warnings)
+	}
+	for i, warning := range warnings {
+		if i < len(expected) && !strings.HasPrefix(warning.String(), expected[i]) {
+			t.Errorf("Expected warning %q, got %q", expected[i], warning.String())
+		}
+	}
+	if _, _, err := Lint(filepath.Join(filepath.Dir(path), "missing.yaml"), ""); err == nil {
+		t.Errorf("Linting a missing file did not error")
+	}
+}
+
+func TestStarter(t *testing.T) {
+	for _, provider := range []string{"Godaddy", "Porkbun"} {
+		data, err := Starter(StarterOptions{Provider: provider, Domain: "example.com", Records: []string{"home", "@"}})
+		if err != nil {
+			t.Fatalf("Generating the %s starter configuration lead to error: %s", provider, err)
+		}
+		config, err := parseConfig(data, "")
+		if err != nil {
+			t.Fatalf("Starter configuration for %s is not valid: %s\n%s", provider, err, data)
+		}
+		records := config.Providers[0].Domains[0].Records
+		if config.Providers[0].Name != provider || len(records) != 2 || records[0].Name != "home" {
+			t.Errorf("Unexpected starter configuration:\n%s", data)
+		}
+	}
+	if _, err := Starter(StarterOptions{Provider: "Cloudflare", Domain: "example.com"}); err == nil {
+		t.Errorf("Starter configuration generated for an unknown provider")
+	}
+}
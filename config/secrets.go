@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// References to environment variables in values, $${NAME} is kept as a literal ${NAME}
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolate replaces the references to environment variables in the scalar values of a YAML document,
+// reporting the missing ones. Comments and keys are left untouched
+func interpolate(node *yaml.Node, v *validator) {
+	if node.Kind == yaml.ScalarNode {
+		var missing []string
+		node.Value = envReference.ReplaceAllStringFunc(node.Value, func(reference string) string {
+			if strings.HasPrefix(reference, "$$") {
+				return reference[1:]
+			}
+			name := envReference.FindStringSubmatch(reference)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			v.addNode(node, "environment variable %s is not set", strings.Join(missing, ", "))
+		}
+		return
+	}
+	for i, child := range node.Content {
+		// Mapping keys are never interpolated
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		interpolate(child, v)
+	}
+}
+
+// secretError is a problem with a credential, Key is the configuration key it comes from, if any
+type secretError struct {
+	Key string
+	Err error
+}
+
+func (e *secretError) Error() string {
+	return e.Err.Error()
+}
+
+// resolveSecret returns the value of a credential given either inline, in a file or in an environment variable.
+// The file, if any, is returned so that it can be watched
+func resolveSecret(field string, value string, file string, env string, dir string) (string, string, error) {
+	set := 0
+	for _, source := range []string{value, file, env} {
+		if source != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", "", &secretError{Key: field, Err: fmt.Errorf("only one of %s, %s_file and %s_env can be set", field, field, field)}
+	}
+	switch {
+	case file != "":
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", &secretError{Key: field + "_file", Err: fmt.Errorf("%s_file could not be read: %s", field, err)}
+		}
+		value = strings.TrimSpace(string(data))
+		if value == "" {
+			return "", "", &secretError{Key: field + "_file", Err: fmt.Errorf("%s_file %s is empty", field, file)}
+		}
+		return value, file, nil
+	case env != "":
+		value = os.Getenv(env)
+		if value == "" {
+			return "", "", &secretError{Key: field + "_env", Err: fmt.Errorf("%s_env %s is not set", field, env)}
+		}
+		return value, "", nil
+	case value == "":
+		return "", "", &secretError{Err: fmt.Errorf("no %s supplied, set one of %s, %s_file or %s_env", field, field, field, field)}
+	}
+	return value, "", nil
+}
+
+// resolveCredentials fills in the credentials of a provider from their sources, relative files are
+// looked up in dir. It returns the secret files which were read, and the problems found
+func (p *ProviderConfiguration) resolveCredentials(dir string) ([]string, []*secretError) {
+	var files []string
+	var errs []*secretError
+	resolve := func(field string, value *string, file string, env string) {
+		resolved, secretFile, err := resolveSecret(field, *value, file, env, dir)
+		if err != nil {
+			errs = append(errs, err.(*secretError))
+			return
+		}
+		*value = resolved
+		if secretFile != "" {
+			files = append(files, secretFile)
+		}
+	}
+	resolve("client_id", &p.ClientID, p.ClientIDFile, p.ClientIDEnv)
+	resolve("client_key", &p.ClientKey, p.ClientKeyFile, p.ClientKeyEnv)
+	return files, errs
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Placeholder of the secret values printed by Show
+const redacted = "<redacted>"
+
+// Keys whose values are secrets, the values of headers are redacted as well since they usually carry tokens,
+// and URLs are masked past their host
+var secretKeys = map[string]bool{
+	"client_id":  true,
+	"client_key": true,
+	"password":   true,
+	"token":      true,
+}
+
+// redactURL masks the parts of a URL which may carry a secret: userinfo, path and query, e.g. the token
+// in the path of a Slack webhook. Scheme and host are kept to tell the destinations apart
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User == nil && strings.Trim(u.Path, "/") == "" && u.RawQuery == "" && u.Fragment == "" {
+		return value
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+// isZero reports whether a node holds an unset value
+func isZero(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		switch node.Value {
+		case "", "0", "false", "0s", "null", "~":
+			return true
+		}
+	case yaml.MappingNode, yaml.SequenceNode:
+		return len(node.Content) == 0
+	}
+	return false
+}
+
+// redact replaces the secrets of a document. When resolved, unset values and the defaults blocks,
+// already merged into the records, are removed as well
+func redact(node *yaml.Node, resolved bool) {
+	if node.Kind == yaml.DocumentNode || node.Kind == yaml.SequenceNode {
+		for _, child := range node.Content {
+			redact(child, resolved)
+		}
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	var content []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if resolved && key.Value == "defaults" {
+			continue
+		}
+		switch {
+		case secretKeys[key.Value] && value.Kind == yaml.ScalarNode && value.Value != "":
+			value.Value, value.Tag, value.Style = redacted, "!!str", 0
+		case (key.Value == "url" || strings.HasSuffix(key.Value, "_url")) && value.Kind == yaml.ScalarNode && value.Value != "":
+			value.Value, value.Tag, value.Style = redactURL(value.Value), "!!str", 0
+		case key.Value == "headers" && value.Kind == yaml.MappingNode:
+			for j := 1; j < len(value.Content); j += 2 {
+				value.Content[j].Value, value.Content[j].Tag, value.Content[j].Style = redacted, "!!str", 0
+			}
+		default:
+			redact(value, resolved)
+		}
+		if resolved && isZero(value) {
+			continue
+		}
+		content = append(content, key, value)
+	}
+	node.Content = content
+}
+
+// Show returns a configuration file with its secrets redacted. The file is decrypted when needed,
+// and every file of a directory is shown as a separate document.
+// When resolved, the configuration is validated and printed as it is applied: included files are merged,
+// environment variables and credential files are read, the defaults are merged into the records, and
+// the defaults of the application are filled in
+func Show(configFile string, format Format, resolved bool) ([]byte, error) {
+	var documents []*yaml.Node
+	if resolved {
+		config, err := ReadConfigFormat(configFile, format)
+		if err != nil {
+			return nil, err
+		}
+		var document yaml.Node
+		if err := document.Encode(config); err != nil {
+			return nil, err
+		}
+		documents = append(documents, &document)
+	} else {
+		files := []string{configFile}
+		if info, err := os.Stat(configFile); err == nil && info.IsDir() {
+			if files, err = configFiles(configFile); err != nil {
+				return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not read directory %s: %s", configFile, err)}
+			}
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not read file %s", file)}
+			}
+			data, err = Decrypt(data)
+			if err != nil {
+				return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not decrypt file %s: %s", file, err)}
+			}
+			fileFormat := format
+			if fileFormat == "" {
+				fileFormat = FormatOf(file)
+			}
+			root, err := parseDocument(data, fileFormat)
+			if err != nil {
+				return nil, &InvalidConfiguration{Description: fmt.Sprintf("Could not parse file %s: %s", file, err)}
+			}
+			if root == nil {
+				continue
+			}
+			document := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+			if len(files) > 1 {
+				document.HeadComment = file
+			}
+			documents = append(documents, document)
+		}
+	}
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, document := range documents {
+		redact(document, resolved)
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	err := encoder.Close()
+	return out.Bytes(), err
+}
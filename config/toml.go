@@ -0,0 +1,361 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Keys which can be written without quotes in TOML
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlReader translates the expressions of a TOML document into YAML nodes
+type tomlReader struct {
+	parser unstable.Parser
+	root   *yaml.Node
+	// Tables defined by a header, which cannot be defined again
+	defined map[*yaml.Node]bool
+}
+
+// position returns the position of a TOML node, or of the fallback node when its bytes are unknown
+func (r *tomlReader) position(node *unstable.Node, fallback *yaml.Node) (int, int) {
+	if node.Raw.Length > 0 {
+		start := r.parser.Shape(node.Raw).Start
+		return start.Line, start.Column
+	}
+	if fallback != nil {
+		return fallback.Line, fallback.Column
+	}
+	return 0, 0
+}
+
+// value translates a TOML value
+func (r *tomlReader) value(node *unstable.Node, key *yaml.Node) (*yaml.Node, error) {
+	line, column := r.position(node, key)
+	out := &yaml.Node{Kind: yaml.ScalarNode, Line: line, Column: column}
+	switch node.Kind {
+	case unstable.String:
+		out.Tag, out.Value = "!!str", string(node.Data)
+	case unstable.Bool:
+		out.Tag, out.Value = "!!bool", string(node.Data)
+	case unstable.Integer:
+		value, err := strconv.ParseInt(string(node.Data), 0, 64)
+		if err != nil {
+			return nil, &positionError{Line: line, Column: column, Message: fmt.Sprintf("invalid integer %s", node.Data)}
+		}
+		out.Tag, out.Value = "!!int", strconv.FormatInt(value, 10)
+	case unstable.Float:
+		value, err := strconv.ParseFloat(strings.Replace(string(node.Data), "_", "", -1), 64)
+		if err != nil {
+			return nil, &positionError{Line: line, Column: column, Message: fmt.Sprintf("invalid float %s", node.Data)}
+		}
+		out.Tag = "!!float"
+		switch {
+		case math.IsNaN(value):
+			out.Value = ".nan"
+		case math.IsInf(value, 1):
+			out.Value = ".inf"
+		case math.IsInf(value, -1):
+			out.Value = "-.inf"
+		default:
+			out.Value = strconv.FormatFloat(value, 'g', -1, 64)
+		}
+	case unstable.LocalDate, unstable.LocalTime, unstable.LocalDateTime, unstable.DateTime:
+		out.Tag, out.Value = "!!str", string(node.Data)
+	case unstable.Array:
+		out.Kind, out.Tag = yaml.SequenceNode, "!!seq"
+		for it := node.Children(); it.Next(); {
+			item, err := r.value(it.Node(), key)
+			if err != nil {
+				return nil, err
+			}
+			out.Content = append(out.Content, item)
+		}
+	case unstable.InlineTable:
+		out.Kind, out.Tag = yaml.MappingNode, "!!map"
+		for it := node.Children(); it.Next(); {
+			if err := r.keyValue(out, it.Node()); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, &positionError{Line: line, Column: column, Message: fmt.Sprintf("unsupported value %s", node.Kind)}
+	}
+	return out, nil
+}
+
+// keys returns the parts of a dotted key
+func (r *tomlReader) keys(it unstable.Iterator) []*yaml.Node {
+	var keys []*yaml.Node
+	for it.Next() {
+		line, column := r.position(it.Node(), nil)
+		keys = append(keys, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(it.Node().Data), Line: line, Column: column})
+	}
+	return keys
+}
+
+// table returns the mapping at a dotted key below a table, creating the missing ones.
+// Arrays of tables resolve to their last table
+func (r *tomlReader) table(parent *yaml.Node, keys []*yaml.Node) (*yaml.Node, error) {
+	for _, key := range keys {
+		existing := mappingValue(parent, key.Value)
+		if existing == nil {
+			existing = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: key.Line, Column: key.Column}
+			parent.Content = append(parent.Content, key, existing)
+		}
+		if existing.Kind == yaml.SequenceNode && len(existing.Content) > 0 && existing.Content[len(existing.Content)-1].Kind == yaml.MappingNode {
+			existing = existing.Content[len(existing.Content)-1]
+		}
+		if existing.Kind != yaml.MappingNode {
+			return nil, &positionError{Line: key.Line, Column: key.Column, Message: fmt.Sprintf("key %s is already defined as a value", key.Value)}
+		}
+		parent = existing
+	}
+	return parent, nil
+}
+
+// keyValue sets a possibly dotted key of a table
+func (r *tomlReader) keyValue(table *yaml.Node, expression *unstable.Node) error {
+	keys := r.keys(expression.Key())
+	parent, err := r.table(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if mappingValue(parent, key.Value) != nil {
+		return &positionError{Line: key.Line, Column: key.Column, Message: fmt.Sprintf("key %s is already defined", key.Value)}
+	}
+	value, err := r.value(expression.Value(), key)
+	if err != nil {
+		return err
+	}
+	parent.Content = append(parent.Content, key, value)
+	return nil
+}
+
+// parseTOML translates a TOML document into the root node of a YAML document, so that it is validated like YAML
+func parseTOML(data []byte) (*yaml.Node, error) {
+	r := &tomlReader{root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}, defined: make(map[*yaml.Node]bool)}
+	r.parser.Reset(data)
+	current := r.root
+	for r.parser.NextExpression() {
+		expression := r.parser.Expression()
+		switch expression.Kind {
+		case unstable.KeyValue:
+			if err := r.keyValue(current, expression); err != nil {
+				return nil, err
+			}
+		case unstable.Table:
+			keys := r.keys(expression.Key())
+			table, err := r.table(r.root, keys)
+			if err != nil {
+				return nil, err
+			}
+			if r.defined[table] {
+				key := keys[len(keys)-1]
+				return nil, &positionError{Line: key.Line, Column: key.Column, Message: fmt.Sprintf("table %s is already defined", key.Value)}
+			}
+			r.defined[table] = true
+			current = table
+		case unstable.ArrayTable:
+			keys := r.keys(expression.Key())
+			parent, err := r.table(r.root, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			key := keys[len(keys)-1]
+			array := mappingValue(parent, key.Value)
+			if array == nil {
+				array = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: key.Line, Column: key.Column}
+				parent.Content = append(parent.Content, key, array)
+			} else if array.Kind != yaml.SequenceNode {
+				return nil, &positionError{Line: key.Line, Column: key.Column, Message: fmt.Sprintf("key %s is already defined as a table", key.Value)}
+			}
+			current = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: key.Line, Column: key.Column}
+			array.Content = append(array.Content, current)
+		}
+	}
+	if err := r.parser.Error(); err != nil {
+		message := err.Error()
+		if parserErr, ok := err.(*unstable.ParserError); ok && len(parserErr.Highlight) > 0 {
+			start := r.parser.Shape(r.parser.Range(parserErr.Highlight)).Start
+			return nil, &positionError{Line: start.Line, Column: start.Column, Message: message}
+		}
+		return nil, fmt.Errorf("%s", message)
+	}
+	return r.root, nil
+}
+
+// tomlKey quotes a key when needed
+func tomlKey(key string) string {
+	if bareKey.MatchString(key) {
+		return key
+	}
+	quoted, _ := json.Marshal(key)
+	return string(quoted)
+}
+
+// isTableArray reports whether a sequence is made of mappings only, written as an array of tables
+func isTableArray(node *yaml.Node) bool {
+	if node.Kind != yaml.SequenceNode || len(node.Content) == 0 {
+		return false
+	}
+	for _, item := range node.Content {
+		if item.Kind != yaml.MappingNode {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlValue writes a value inline
+func tomlValue(out *bytes.Buffer, node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		out.WriteString("{")
+		first := true
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].ShortTag() == "!!null" {
+				continue
+			}
+			if !first {
+				out.WriteString(",")
+			}
+			first = false
+			out.WriteString(" " + tomlKey(node.Content[i].Value) + " = ")
+			if err := tomlValue(out, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		if !first {
+			out.WriteString(" ")
+		}
+		out.WriteString("}")
+	case yaml.SequenceNode:
+		out.WriteString("[")
+		for i, item := range node.Content {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			if err := tomlValue(out, item); err != nil {
+				return err
+			}
+		}
+		out.WriteString("]")
+	default:
+		switch node.ShortTag() {
+		case "!!null":
+			return fmt.Errorf("line %d: TOML has no null values", node.Line)
+		case "!!float":
+			var value float64
+			if err := node.Decode(&value); err != nil {
+				return err
+			}
+			switch {
+			case math.IsNaN(value):
+				out.WriteString("nan")
+			case math.IsInf(value, 1):
+				out.WriteString("inf")
+			case math.IsInf(value, -1):
+				out.WriteString("-inf")
+			default:
+				formatted := strconv.FormatFloat(value, 'g', -1, 64)
+				if !strings.ContainsAny(formatted, ".eEn") {
+					formatted += ".0"
+				}
+				out.WriteString(formatted)
+			}
+		case "!!int", "!!bool":
+			value, err := scalarJSON(node)
+			if err != nil {
+				return err
+			}
+			out.Write(value)
+		default:
+			// Strings, and timestamps which are kept as written
+			var value bytes.Buffer
+			encoder := json.NewEncoder(&value)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(node.Value); err != nil {
+				return err
+			}
+			out.Write(bytes.TrimSuffix(value.Bytes(), []byte("\n")))
+		}
+	}
+	return nil
+}
+
+// tomlTable writes the keys of a table: its values first, then its tables and arrays of tables
+func tomlTable(out *bytes.Buffer, node *yaml.Node, path []string) error {
+	var tables []int
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		value := node.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if (value.Kind == yaml.MappingNode && len(value.Content) > 0) || isTableArray(value) {
+			tables = append(tables, i)
+			continue
+		}
+		// Null values are left out, as if they were not set
+		if value.ShortTag() == "!!null" {
+			continue
+		}
+		out.WriteString(tomlKey(node.Content[i].Value) + " = ")
+		if err := tomlValue(out, value); err != nil {
+			return err
+		}
+		out.WriteString("\n")
+	}
+	for _, i := range tables {
+		value := node.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		tablePath := append(append([]string(nil), path...), tomlKey(node.Content[i].Value))
+		header := strings.Join(tablePath, ".")
+		if value.Kind == yaml.MappingNode {
+			out.WriteString("\n[" + header + "]\n")
+			if err := tomlTable(out, value, tablePath); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, item := range value.Content {
+			if item.Kind == yaml.AliasNode {
+				item = item.Alias
+			}
+			out.WriteString("\n[[" + header + "]]\n")
+			if err := tomlTable(out, item, tablePath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTOML writes a document as TOML, mappings become tables and lists of mappings arrays of tables
+func writeTOML(out *bytes.Buffer, root *yaml.Node) error {
+	if root.Kind == yaml.AliasNode {
+		root = root.Alias
+	}
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: the configuration is not a mapping", root.Line)
+	}
+	var table bytes.Buffer
+	if err := tomlTable(&table, root, nil); err != nil {
+		return err
+	}
+	out.Write(bytes.TrimPrefix(table.Bytes(), []byte("\n")))
+	return nil
+}
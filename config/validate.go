@@ -0,0 +1,502 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/facts"
+	"github.com/sudneo/home-ddns/models"
+	"github.com/sudneo/home-ddns/rdata"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Problem is a single error found in a configuration, positions are 0 when unknown
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	var position []string
+	if p.File != "" {
+		position = append(position, p.File)
+	}
+	if p.Line > 0 {
+		position = append(position, strconv.Itoa(p.Line))
+		if p.Column > 0 {
+			position = append(position, strconv.Itoa(p.Column))
+		}
+	}
+	if len(position) == 0 {
+		return p.Message
+	}
+	return strings.Join(position, ":") + ": " + p.Message
+}
+
+// Record types which can be configured
+var recordTypes = []string{"A", "AAAA", "CAA", "CNAME", "MX", "NS", "SRV", "TXT"}
+
+// Maximum TTL allowed by RFC 2181
+const maxTTL = math.MaxInt32
+
+// Lines reported by yaml.v3 in its errors
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// validator collects the problems of a configuration, locating them through the nodes of the document
+type validator struct {
+	file string
+	// File of the nodes read from the configuration files, nodes not found belong to file
+	origins  map[*yaml.Node]string
+	nodes    map[string]*yaml.Node
+	problems []Problem
+	// IP sources the records can reference
+	sources map[string]facts.Source
+	// Path of each record by provider, domain, type and name, to detect the ones defined in more than one file
+	defined map[string]string
+}
+
+func newValidator(file string) *validator {
+	return &validator{file: file, origins: make(map[*yaml.Node]string), nodes: make(map[string]*yaml.Node)}
+}
+
+// setOrigin records the file of a node and of its children which have none yet
+func (v *validator) setOrigin(node *yaml.Node, file string) {
+	if origin, ok := v.origins[node]; ok {
+		file = origin
+	} else {
+		v.origins[node] = file
+	}
+	for _, child := range node.Content {
+		v.setOrigin(child, file)
+	}
+}
+
+// fileOf returns the file a node was read from
+func (v *validator) fileOf(node *yaml.Node) string {
+	if file, ok := v.origins[node]; ok {
+		return file
+	}
+	return v.file
+}
+
+// position returns the location of a node, as in the problems
+func (v *validator) position(node *yaml.Node) string {
+	return strings.TrimSuffix(Problem{File: v.fileOf(node), Line: node.Line, Column: node.Column}.String(), ": ")
+}
+
+// dirOf returns the directory of the file defining a path, relative paths found there are relative to it
+func (v *validator) dirOf(path string) string {
+	for {
+		if node, ok := v.nodes[path]; ok {
+			return filepath.Dir(v.fileOf(node))
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			return filepath.Dir(v.file)
+		}
+		path = path[:cut]
+	}
+}
+
+// index records the node of every value by its path, e.g. providers[0].domains[1].domain
+func (v *validator) index(node *yaml.Node, path string) {
+	v.nodes[path] = node
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.index(node.Content[i+1], joinPath(path, node.Content[i].Value))
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			v.index(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// addNode reports a problem at the position of a node
+func (v *validator) addNode(node *yaml.Node, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{File: v.fileOf(node), Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// addf reports a problem at the position of a path, or of its closest parent when the path is not in the document
+func (v *validator) addf(path string, format string, args ...interface{}) {
+	for {
+		if node, ok := v.nodes[path]; ok {
+			v.addNode(node, format, args...)
+			return
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	if node, ok := v.nodes[""]; ok {
+		v.addNode(node, format, args...)
+		return
+	}
+	v.problems = append(v.problems, Problem{File: v.file, Message: fmt.Sprintf(format, args...)})
+}
+
+// addYAMLError reports the errors returned by yaml.v3 for a file, which carry the line only,
+// and the syntax errors of the other formats
+func (v *validator) addYAMLError(file string, err error) {
+	if positionErr, ok := err.(*positionError); ok {
+		v.problems = append(v.problems, Problem{File: file, Line: positionErr.Line, Column: positionErr.Column, Message: positionErr.Message})
+		return
+	}
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+	for _, message := range messages {
+		problem := Problem{File: file, Message: message}
+		if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+			problem.Line, _ = strconv.Atoi(match[1])
+			problem.Message = match[2]
+		}
+		v.problems = append(v.problems, problem)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	sortProblems(v.problems)
+	return &InvalidConfiguration{Description: fmt.Sprintf("%d problems found", len(v.problems)), Problems: v.problems}
+}
+
+// yamlFields returns the type of each field of a struct by its YAML key
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		inline := false
+		for _, flag := range tag[1:] {
+			inline = inline || flag == "inline"
+		}
+		if inline && field.Type.Kind() == reflect.Struct {
+			for key, ft := range yamlFields(field.Type) {
+				fields[key] = ft
+			}
+			continue
+		}
+		key := tag[0]
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		fields[key] = field.Type
+	}
+	return fields
+}
+
+// distance is the Levenshtein distance between two strings, used to suggest known fields
+func distance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// checkFields reports the keys of the document which do not match any field of the type they are decoded into
+func (v *validator) checkFields(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Value == "<<" {
+				continue
+			}
+			ft, ok := fields[key.Value]
+			if !ok {
+				location := ""
+				if path != "" {
+					location = " in " + path
+				}
+				suggestion := ""
+				best := 3
+				for known := range fields {
+					if d := distance(key.Value, known); d < best || (d == best && known < suggestion) {
+						best, suggestion = d, known
+					}
+				}
+				if suggestion != "" {
+					suggestion = fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				v.addNode(key, "unknown field %q%s%s", key.Value, location, suggestion)
+				continue
+			}
+			v.checkFields(node.Content[i+1], ft, joinPath(path, key.Value))
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			v.checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkFields(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+		}
+	}
+}
+
+// validRecordName checks the name of a record relative to its domain: @ for the apex, or labels with an optional leading wildcard
+func validRecordName(name string) bool {
+	if name == "@" || name == "*" {
+		return true
+	}
+	return rdata.ValidHostname(strings.TrimPrefix(name, "*."))
+}
+
+func isRecordType(recordType string) bool {
+	for _, t := range recordTypes {
+		if t == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRecord checks a record on its own, path locates it in the document
+func (v *validator) validateRecord(record models.DNSRecord, path string) {
+	if record.Name == "" {
+		v.addf(joinPath(path, "name"), "record name is required, use @ for the domain itself")
+	} else if !validRecordName(record.Name) {
+		v.addf(joinPath(path, "name"), "invalid record name %q", record.Name)
+	}
+	if record.Type == "" {
+		v.addf(joinPath(path, "type"), "record type is required")
+	} else if !isRecordType(record.Type) {
+		v.addf(joinPath(path, "type"), "unsupported record type %q, expected one of %s", record.Type, strings.Join(recordTypes, ", "))
+	}
+	value := record.Value
+	if facts.IsTemplate(value) {
+		// Templated values are only known once rendered, their format is checked by the engine
+		if err := facts.Check(value, v.sources); err != nil {
+			v.addf(joinPath(path, "value"), "invalid value template: %s", err)
+		}
+		value = ""
+	}
+	switch record.Type {
+	case "MX", "NS", "SRV", "TXT", "CAA":
+		if record.Value == "" {
+			v.addf(path, "value is required for %s records", record.Type)
+		}
+	}
+	if value != "" && isRecordType(record.Type) {
+		if _, err := rdata.Normalize(record.Type, "", value); err != nil {
+			v.addf(joinPath(path, "value"), "%s", err)
+		}
+	}
+	for _, err := range rdata.Check(record) {
+		v.addf(joinPath(path, err.Field), "%s", err.Message)
+	}
+	if record.Source != "" {
+		if _, ok := v.sources[record.Source]; !ok {
+			if len(v.sources) == 0 {
+				v.addf(joinPath(path, "source"), "unknown IP source %q, no ip_sources are configured", record.Source)
+			} else {
+				v.addf(joinPath(path, "source"), "unknown IP source %q, expected one of %s", record.Source, strings.Join(facts.SourceNames(v.sources), ", "))
+			}
+		}
+	}
+	if record.TTL < 0 || record.TTL > maxTTL {
+		v.addf(joinPath(path, "ttl"), "ttl %d out of range, expected 0 (provider default) to %d", record.TTL, maxTTL)
+	}
+	for field, value := range map[string]*int{"priority": record.Priority, "weight": record.Weight, "port": &record.Port} {
+		if value != nil && (*value < 0 || *value > 65535) {
+			v.addf(joinPath(path, field), "%s %d out of range, expected 0 to 65535", field, *value)
+		}
+	}
+}
+
+// validateProvider checks a provider account and its domains, resolving its credentials
+// relatively to the file defining the account
+func (v *validator) validateProvider(provider *ProviderConfiguration, path string) []string {
+	if provider.Name == "" {
+		v.addf(joinPath(path, "name"), "provider name is required, expected one of %s", strings.Join(api.Providers(), ", "))
+	} else if !api.IsRegistered(provider.Name) {
+		v.addf(joinPath(path, "name"), "unknown provider %q, expected one of %s", provider.Name, strings.Join(api.Providers(), ", "))
+	}
+	secrets, errs := provider.resolveCredentials(v.dirOf(path))
+	for _, err := range errs {
+		if err.Key == "" {
+			v.addf(path, "invalid credentials: %s", err)
+		} else {
+			v.addf(joinPath(path, err.Key), "invalid credentials: %s", err)
+		}
+	}
+	if err := provider.Retry.Validate(); err != nil {
+		v.addf(joinPath(path, "retry"), "invalid retry policy: %s", err)
+	}
+	if provider.Schedule != nil {
+		if _, err := provider.Schedule.Parse(); err != nil {
+			v.addf(joinPath(path, "schedule"), "invalid schedule: %s", err)
+		}
+	}
+	capabilities := api.ProviderCapabilities(provider.Name)
+	for i, domain := range provider.Domains {
+		domainPath := fmt.Sprintf("%s.domains[%d]", path, i)
+		if domain.Domain == "" {
+			v.addf(joinPath(domainPath, "domain"), "domain is required")
+		} else if !rdata.ValidHostname(domain.Domain) {
+			v.addf(joinPath(domainPath, "domain"), "invalid domain %q", domain.Domain)
+		}
+		if domain.Schedule != nil {
+			if _, err := domain.Schedule.Parse(); err != nil {
+				v.addf(joinPath(domainPath, "schedule"), "invalid schedule: %s", err)
+			}
+		}
+		for j, record := range domain.Records {
+			recordPath := fmt.Sprintf("%s.records[%d]", domainPath, j)
+			v.validateRecord(record, recordPath)
+			if isRecordType(record.Type) && !capabilities.Supports(record.Type) {
+				v.addf(joinPath(recordPath, "type"), "%s records are not supported by %s, expected one of %s", record.Type, provider.Name, strings.Join(capabilities.Types, ", "))
+			}
+			// Accounts of the same provider in different files may manage the same record, even without ids
+			key := strings.ToLower(strings.Join([]string{provider.Name, domain.Domain, record.Type, record.Name}, " "))
+			first, ok := v.defined[key]
+			if !ok {
+				v.defined[key] = recordPath
+				continue
+			}
+			if !capabilities.MultiValue {
+				v.addf(recordPath, "%s record %s of %s is already defined at %s, %s does not support several values per name", record.Type, record.Name, domain.Domain, v.position(v.nodes[first]), provider.Name)
+				continue
+			}
+			// Duplicates within a file are reported by the linter, a file may list a domain more than once
+			if v.fileOf(v.nodes[first]) != v.fileOf(v.nodes[recordPath]) {
+				v.addf(recordPath, "%s record %s of %s is already defined at %s", record.Type, record.Name, domain.Domain, v.position(v.nodes[first]))
+			}
+		}
+	}
+	return secrets
+}
+
+// validate checks a decoded configuration, relative paths are relative to the file they are found in.
+// Credentials are resolved and defaults applied in the process
+func (v *validator) validate(config *Config) {
+	if len(config.Providers) == 0 {
+		v.addf("providers", "no provider configuration supplied")
+	}
+	for _, name := range facts.SourceNames(config.IPSources) {
+		if err := config.IPSources[name].Validate(); err != nil {
+			v.addf(joinPath("ip_sources", name), "invalid IP source: %s", err)
+		}
+	}
+	v.sources = config.IPSources
+	v.defined = make(map[string]string)
+	totalDomains := 0
+	ids := make(map[string]bool)
+	for i := range config.Providers {
+		provider := &config.Providers[i]
+		path := fmt.Sprintf("providers[%d]", i)
+		totalDomains += len(provider.Domains)
+		config.files = append(config.files, v.validateProvider(provider, path)...)
+		if provider.ID != "" {
+			if ids[provider.ID] {
+				v.addf(joinPath(path, "id"), "provider id %s is used more than once", provider.ID)
+			}
+			ids[provider.ID] = true
+		}
+	}
+	if len(config.Providers) > 0 && totalDomains == 0 {
+		v.addf("providers", "no domain configuration supplied")
+	}
+	// The global schedule is optional, cron mode falls back to its interval
+	if config.Schedule.Cron != "" || config.Schedule.Jitter != 0 {
+		if _, err := config.Schedule.Parse(); err != nil {
+			v.addf("schedule", "invalid schedule: %s", err)
+		}
+	}
+	if config.Timeouts.Call < 0 {
+		v.addf("timeouts.call", "timeout must not be negative")
+	}
+	if config.Timeouts.Run < 0 {
+		v.addf("timeouts.run", "timeout must not be negative")
+	}
+	if config.Timeouts.Call == 0 {
+		config.Timeouts.Call = defaultCallTimeout
+	}
+	if config.Timeouts.Run == 0 {
+		config.Timeouts.Run = defaultRunTimeout
+	}
+	for i, notification := range config.Notifications {
+		if err := notification.Validate(); err != nil {
+			v.addf(fmt.Sprintf("notifications[%d]", i), "invalid notification: %s", err)
+		}
+	}
+	if err := config.History.Validate(); err != nil {
+		v.addf("history", "invalid history: %s", err)
+	}
+	config.History.Path = resolvePath(v.dirOf("history.path"), config.History.Path)
+	if err := config.Propagation.Validate(); err != nil {
+		v.addf("propagation", "invalid propagation: %s", err)
+	}
+}
+
+// sortProblems orders problems by position
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+}
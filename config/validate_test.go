@@ -0,0 +1,282 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sudneo/home-ddns/api"
+)
+
+var invalidConfig = []byte(`providers:
+  - name: Godady
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: home
+            type: A
+            ttls: 600
+          - name: www
+            type: AAAA
+            value: 192.0.2.1
+          - name: "bad name!"
+            type: SPF
+            ttl: -1
+          - name: mail
+            type: MX
+            priority: 70000
+  - name: Porkbun
+    client_id: id
+    domains:
+      - domain: example.org
+        records:
+          - name: vpn
+            type: CNAME
+            value: not/a/host
+timeouts:
+  call: forever
+`)
+
+func TestValidation(t *testing.T) {
+	_, err := parseConfig(invalidConfig, "config.yaml")
+	invalid, ok := err.(*InvalidConfiguration)
+	if !ok {
+		t.Fatalf("Expected *InvalidConfiguration, got %v", err)
+	}
+	expected := []string{
+		`config.yaml:2:11: unknown provider "Godady", expected one of Godaddy, Porkbun`,
+		`config.yaml:10:13: unknown field "ttls" in providers[0].domains[0].records[0], did you mean "ttl"?`,
+		`config.yaml:13:20: value "192.0.2.1" of AAAA record is not an IPv6 address`,
+		`config.yaml:14:19: invalid record name "bad name!"`,
+		`config.yaml:15:19: unsupported record type "SPF"`,
+		`config.yaml:16:18: ttl -1 out of range`,
+		`config.yaml:17:13: value is required for MX records`,
+		`config.yaml:19:23: priority 70000 out of range`,
+		`config.yaml:20:5: invalid credentials: no client_key supplied`,
+		`config.yaml:27:20: value "not/a/host" of CNAME record is not a host name`,
+		`config.yaml:29:`,
+	}
+	if len(invalid.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d:\n%s", len(expected), len(invalid.Problems), err)
+	}
+	for i, problem := range invalid.Problems {
+		if i < len(expected) && !strings.HasPrefix(problem.String(), expected[i]) {
+			t.Errorf("Expected problem %q, got %q", expected[i], problem.String())
+		}
+	}
+
+	_, err = parseConfig([]byte("providers:\n  - name: [Godaddy\n"), "broken.yaml")
+	if err == nil || !strings.Contains(err.Error(), "broken.yaml:") {
+		t.Errorf("Expected syntax error with position, got %v", err)
+	}
+	_, err = parseConfig([]byte(""), "empty.yaml")
+	if err == nil || !strings.Contains(err.Error(), "no provider configuration supplied") {
+		t.Errorf("Expected missing providers error, got %v", err)
+	}
+}
+
+func TestValueTemplates(t *testing.T) {
+	records := `providers:
+  - name: Godaddy
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: "@"
+            type: TXT
+            value: "v=spf1 ip4:{{.IPv4}} -all"
+          - name: nas
+            type: AAAA
+            value: '{{host .Prefix "::10"}}'
+          - name: lan
+            type: A
+            value: '{{.InterfaceIPv4 "eth0"'
+          - name: mail
+            type: MX
+            value: "{{.Mailserver}}"
+            priority: 10
+`
+	_, err := parseConfig([]byte(records), "config.yaml")
+	invalid, ok := err.(*InvalidConfiguration)
+	if !ok {
+		t.Fatalf("Expected *InvalidConfiguration, got %v", err)
+	}
+	expected := []string{
+		`config.yaml:16:20: invalid value template: template: value:1: unclosed action`,
+		`config.yaml:19:20: invalid value template: template: value:1:2: executing "value" at <.Mailserver>: can't evaluate field Mailserver`,
+	}
+	if len(invalid.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d:\n%s", len(expected), len(invalid.Problems), err)
+	}
+	for i, problem := range invalid.Problems {
+		if i < len(expected) && !strings.HasPrefix(problem.String(), expected[i]) {
+			t.Errorf("Expected problem %q, got %q", expected[i], problem.String())
+		}
+	}
+}
+
+func TestIPSources(t *testing.T) {
+	sources := `ip_sources:
+  vpn:
+    type: interface
+    interface: wg0
+  wan2:
+    interface: ppp1
+    url: https://api.ipify.org
+defaults:
+  source: vpn
+providers:
+  - name: Godaddy
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: nas
+            type: A
+          - name: shop
+            type: AAAA
+            source: wan2
+`
+	config, err := parseConfig([]byte(sources), "config.yaml")
+	if err != nil {
+		t.Fatalf("Parsing the configuration with IP sources lead to error: %s", err)
+	}
+	records := config.Providers[0].Domains[0].Records
+	if records[0].Source != "vpn" || records[1].Source != "wan2" || config.IPSources["vpn"].Interface != "wg0" {
+		t.Errorf("Unexpected IP sources %+v %+v", records, config.IPSources)
+	}
+
+	_, err = parseConfig([]byte(strings.Replace(strings.Replace(sources, "type: interface", "type: vpn", 1), "source: wan2", "source: lte", 1)), "config.yaml")
+	invalid, ok := err.(*InvalidConfiguration)
+	if !ok {
+		t.Fatalf("Expected *InvalidConfiguration, got %v", err)
+	}
+	expected := []string{
+		`config.yaml:3:5: invalid IP source: unknown source type "vpn"`,
+		`config.yaml:21:21: unknown IP source "lte", expected one of vpn, wan2`,
+	}
+	if len(invalid.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d:\n%s", len(expected), len(invalid.Problems), err)
+	}
+	for i, problem := range invalid.Problems {
+		if i < len(expected) && !strings.HasPrefix(problem.String(), expected[i]) {
+			t.Errorf("Expected problem %q, got %q", expected[i], problem.String())
+		}
+	}
+}
+
+func TestRecordValues(t *testing.T) {
+	records := `providers:
+  - name: Godaddy
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: "@"
+            type: CAA
+            value: letsencrypt.org
+          - name: "@"
+            type: TXT
+            value: '"v=spf1 -all'
+          - name: _sip._tcp
+            type: SRV
+            value: sip.example.com
+            service: sip
+          - name: "@"
+            type: MX
+            value: "@"
+          - name: backup
+            type: MX
+            value: mx.example.com
+            priority: 0
+`
+	_, err := parseConfig([]byte(records), "config.yaml")
+	invalid, ok := err.(*InvalidConfiguration)
+	if !ok {
+		t.Fatalf("Expected *InvalidConfiguration, got %v", err)
+	}
+	expected := []string{
+		`config.yaml:10:20: value "letsencrypt.org" of CAA record is not in the form flags tag "value"`,
+		`config.yaml:13:20: value "\"v=spf1 -all" of TXT record: unterminated quoted string`,
+		`config.yaml:14:13: protocol is required for SRV records, e.g. _tcp`,
+		`config.yaml:14:13: weight is required for SRV records`,
+		`config.yaml:14:13: port is required for SRV records`,
+		`config.yaml:17:22: invalid service "sip" of SRV record, expected e.g. _sip`,
+		`config.yaml:18:13: priority is required for MX records`,
+	}
+	if len(invalid.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d:\n%s", len(expected), len(invalid.Problems), err)
+	}
+	for i, problem := range invalid.Problems {
+		if i < len(expected) && !strings.HasPrefix(problem.String(), expected[i]) {
+			t.Errorf("Expected problem %q, got %q", expected[i], problem.String())
+		}
+	}
+}
+
+func TestUnsupportedTypes(t *testing.T) {
+	previous := api.ProviderCapabilities(api.PorkbunProvider)
+	defer api.RegisterCapabilities(api.PorkbunProvider, previous)
+	api.RegisterCapabilities(api.PorkbunProvider, api.Capabilities{Types: []string{"A", "AAAA"}})
+	_, err := parseConfig([]byte(`providers:
+  - name: Porkbun
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: home
+            type: A
+          - name: "@"
+            type: TXT
+            value: "v=spf1 -all"
+`), "config.yaml")
+	expected := "config.yaml:11:19: TXT records are not supported by Porkbun, expected one of A, AAAA"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got %v", expected, err)
+	}
+
+	// Several values of a name need a multi-value provider
+	_, err = parseConfig([]byte(`providers:
+  - name: Porkbun
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.com
+        records:
+          - name: lb
+            type: A
+            value: 192.0.2.1
+          - name: lb
+            type: A
+            value: 192.0.2.2
+`), "config.yaml")
+	expected = "config.yaml:11:13: A record lb of example.com is already defined at config.yaml:8:13, Porkbun does not support several values per name"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got %v", expected, err)
+	}
+}
+
+func TestValidRecordName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"@":             true,
+		"*":             true,
+		"*.home":        true,
+		"_sip._tcp":     true,
+		"home.lab":      true,
+		"-home":         false,
+		"home..lab":     false,
+		"home lab":      false,
+		"home.*":        false,
+		"":              false,
+		"a.b.c.d.e.f.g": true,
+	} {
+		if validRecordName(name) != valid {
+			t.Errorf("Expected validity of %q to be %v", name, valid)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Delay without further file events before the configuration is reloaded
+const reloadDebounce = 250 * time.Millisecond
+
+// Loader reads and validates the configuration from a file
+type Loader func(configFile string) (Config, error)
+
+// Watcher keeps the last valid configuration read from a file, reloading it when the file changes.
+// Invalid configurations are reported and ignored, so that the last valid one stays in use
+type Watcher struct {
+	path string
+	load Loader
+	// Serializes reloads, so that one requested through SIGHUP cannot overwrite a newer one of the watch
+	reloadMu sync.Mutex
+	mu       sync.Mutex
+	current  Config
+	changed  chan struct{}
+}
+
+// NewWatcher loads the configuration for the first time, failing if it is not valid
+func NewWatcher(path string, load Loader) (*Watcher, error) {
+	config, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{path: path, load: load, current: config, changed: make(chan struct{}, 1)}, nil
+}
+
+// Config returns the last valid configuration
+func (w *Watcher) Config() Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Changed is notified whenever a reload changed the configuration
+func (w *Watcher) Changed() <-chan struct{} {
+	return w.changed
+}
+
+// Reload reads the configuration again, replacing the current one only if valid.
+// It is safe to call while Watch runs
+func (w *Watcher) Reload() error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+	config, err := w.load(w.path)
+	if err != nil {
+		var summary interface{} = err
+		var invalid *InvalidConfiguration
+		// Problems are logged one per line, the summary only tells how many were found
+		if errors.As(err, &invalid) && len(invalid.Problems) > 0 {
+			for _, problem := range invalid.Problems {
+				log.Error(problem.String())
+			}
+			summary = invalid.Description
+		}
+		log.WithFields(log.Fields{
+			"Error": summary,
+			"File":  w.path,
+		}).Error("Invalid configuration, keeping the last valid one")
+		return err
+	}
+	w.mu.Lock()
+	unchanged := reflect.DeepEqual(config, w.current)
+	w.current = config
+	w.mu.Unlock()
+	if unchanged {
+		log.Debug("Configuration reloaded without changes")
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"File": w.path,
+	}).Info("Configuration reloaded")
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// watchedDirs returns the directories containing the configuration files and the targets of their symlinks.
+// Directories are watched rather than files, so that atomic replacements (editors saving through a rename,
+// Kubernetes swapping the ..data symlink of ConfigMaps) are noticed
+func (w *Watcher) watchedDirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	config := w.Config()
+	for _, file := range config.Files() {
+		add(filepath.Dir(file))
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			add(filepath.Dir(resolved))
+		}
+	}
+	// Directories of included patterns and configuration directories, to notice new files
+	for _, pattern := range config.patterns {
+		if dir := filepath.Dir(pattern); !hasMeta(dir) {
+			add(dir)
+		}
+	}
+	return dirs
+}
+
+// relevant reports whether an event on the given path may affect the configuration files:
+// the files themselves, the targets of their symlinks, new files matching the included patterns,
+// or the ..data entries of Kubernetes volumes
+func (w *Watcher) relevant(path string) bool {
+	path = filepath.Clean(path)
+	config := w.Config()
+	for _, pattern := range config.patterns {
+		if matched, _ := filepath.Match(pattern, path); matched && !strings.HasPrefix(filepath.Base(path), ".") {
+			return true
+		}
+	}
+	for _, file := range config.Files() {
+		file = filepath.Clean(file)
+		if path == file {
+			return true
+		}
+		if resolved, err := filepath.EvalSymlinks(file); err == nil && path == resolved {
+			return true
+		}
+		if filepath.Dir(path) == filepath.Dir(file) && strings.HasPrefix(filepath.Base(path), "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the configuration whenever its files change, until the context is cancelled
+func (w *Watcher) Watch(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	watched := make(map[string]bool)
+	updateWatches := func() {
+		for _, dir := range w.watchedDirs() {
+			if watched[dir] {
+				continue
+			}
+			if err := fw.Add(dir); err != nil {
+				log.WithFields(log.Fields{
+					"Error":     err,
+					"Directory": dir,
+				}).Warn("Failed to watch configuration directory")
+				continue
+			}
+			watched[dir] = true
+		}
+	}
+	updateWatches()
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if !w.relevant(event.Name) {
+				continue
+			}
+			log.WithFields(log.Fields{
+				"File":  event.Name,
+				"Event": event.Op.String(),
+			}).Debug("Configuration directory changed")
+			debounce = time.After(reloadDebounce)
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Warn("Error while watching configuration files")
+		case <-debounce:
+			debounce = nil
+			w.Reload()
+			// Symlinks may point somewhere else after the reload
+			updateWatches()
+		}
+	}
+}
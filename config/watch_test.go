@@ -0,0 +1,199 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitChanged(t *testing.T, w *Watcher) bool {
+	t.Helper()
+	select {
+	case <-w.Changed():
+		return true
+	case <-time.After(3 * time.Second):
+		return false
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, validConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher(path, ReadConfig)
+	if err != nil {
+		t.Fatalf("Creating the watcher lead to error: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Watch(ctx)
+	// Give the watcher time to register
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(path, complexConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !waitChanged(t, w) {
+		t.Fatalf("Change of the configuration file not detected")
+	}
+	if len(w.Config().Providers) != 2 {
+		t.Errorf("Configuration not reloaded")
+	}
+	// An invalid configuration is ignored, keeping the last valid one
+	if err := os.WriteFile(path, missingKeyConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * reloadDebounce)
+	if len(w.Config().Providers) != 2 {
+		t.Errorf("Invalid configuration replaced the last valid one")
+	}
+}
+
+func TestWatcherConcurrentReload(t *testing.T) {
+	// The loader is not safe for concurrent use, which the race detector reports if reloads overlap
+	loads := 0
+	w, err := NewWatcher("config.yaml", func(string) (Config, error) {
+		loads++
+		return Config{Timeouts: TimeoutConfiguration{Run: time.Duration(loads)}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Reload()
+		}()
+	}
+	wg.Wait()
+	if w.Config().Timeouts.Run != time.Duration(loads) {
+		t.Errorf("Configuration of load %d replaced the last one, %d", w.Config().Timeouts.Run, loads)
+	}
+}
+
+func TestWatcherSymlinkSwap(t *testing.T) {
+	// Reproduce the layout of a Kubernetes ConfigMap volume
+	dir := t.TempDir()
+	first := filepath.Join(dir, "..2024_01_01")
+	second := filepath.Join(dir, "..2024_01_02")
+	for _, d := range []string{first, second} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(first, "config.yaml"), validConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(second, "config.yaml"), complexConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Base(first), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher(path, ReadConfig)
+	if err != nil {
+		t.Fatalf("Creating the watcher lead to error: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Watch(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(second), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitChanged(t, w) {
+		t.Fatalf("Swap of the ..data symlink not detected")
+	}
+	if len(w.Config().Providers) != 2 {
+		t.Errorf("Configuration not reloaded after the symlink swap")
+	}
+}
+
+func TestWatcherSecretRotation(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets")
+	if err := os.Mkdir(secrets, 0700); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(secrets, "key")
+	if err := os.WriteFile(keyFile, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+providers:
+  - name: Godaddy
+    client_id: id
+    client_key_file: secrets/key
+    domains:
+      - domain: example.com
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher(path, ReadConfig)
+	if err != nil {
+		t.Fatalf("Creating the watcher lead to error: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Watch(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(keyFile, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !waitChanged(t, w) {
+		t.Fatalf("Rotation of the secret not detected")
+	}
+	if key := w.Config().Providers[0].ClientKey; key != "second" {
+		t.Errorf("Expected the rotated key, found %s", key)
+	}
+}
+
+func TestWatcherConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "godaddy.yaml"), validConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher(dir, ReadConfig)
+	if err != nil {
+		t.Fatalf("Creating the watcher lead to error: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Watch(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(filepath.Join(dir, "porkbun.yaml"), []byte(`
+providers:
+  - name: Porkbun
+    client_id: id
+    client_key: key
+    domains:
+      - domain: example.org
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !waitChanged(t, w) {
+		t.Fatalf("New file in the configuration directory not detected")
+	}
+	if len(w.Config().Providers) != 2 {
+		t.Errorf("Configuration not reloaded with the new file")
+	}
+}
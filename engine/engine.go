@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/config"
+	"github.com/sudneo/home-ddns/facts"
+	"github.com/sudneo/home-ddns/metrics"
+	"github.com/sudneo/home-ddns/models"
+	"github.com/sudneo/home-ddns/propagation"
+	"github.com/sudneo/home-ddns/rdata"
+	"github.com/sudneo/home-ddns/report"
+)
+
+// processRecord brings a single record up-to-date with the configuration
+func processRecord(ctx context.Context, domain string, record models.DNSRecord, handler models.Provider, f *facts.Facts, result *report.RecordResult) {
+	if facts.IsTemplate(record.Value) {
+		value, err := renderValue(domain, record, f)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":  err,
+				"Record": record.Name,
+			}).Error("Failed to render DNS record value")
+			result.Outcome = report.Failed
+			result.Error = err.Error()
+			return
+		}
+		record.Value = value
+	}
+	dnsRecord, err := handler.GetRecord(ctx, domain, record)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error":  err,
+			"Record": record.Name,
+		}).Error("Failed to process DNS record")
+		result.Outcome = report.Failed
+		result.Error = err.Error()
+		return
+	}
+	// If the DNS record does not have a value specified, set sane defaults
+	if record.Value == "" {
+		if record.Type == "CNAME" {
+			// The domain itself, which unlike @ is understood by every provider
+			record.Value = domain
+		} else {
+			// The public IP when no source is named, of the family of the record
+			address, err := f.Address(record.Source, record.Type != "AAAA")
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Error":  err,
+					"Record": record.Name,
+					"Source": record.Source,
+				}).Error("Failed to discover the address of DNS record")
+				result.Outcome = report.Failed
+				result.Error = err.Error()
+				return
+			}
+			record.Value = address
+		}
+	}
+	if record.Type == "TXT" {
+		record.Value = rdata.SplitTXT(record.Value)
+	}
+	result.Desired = record.Value
+	result.Observed = dnsRecord.Value
+	// If the current record does not exist, the DNS record must be created
+	if dnsRecord.Value == "" {
+		log.WithFields(log.Fields{
+			"Name": record.Name,
+		}).Debug("Not found existing record for domain, creating a new one")
+		result.Action = report.Create
+		err = handler.SetRecord(ctx, domain, record)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":  err,
+				"Record": record.Name,
+			}).Error("Failed to create DNS record")
+			result.Outcome = report.Failed
+			result.Error = err.Error()
+			return
+		}
+		log.WithFields(log.Fields{
+			"Record": record.Name,
+		}).Info("Successfully created DNS record")
+		result.Outcome = report.Created
+		return
+	}
+	// If the record does exist, but it's not up-to-date, update it. Values are compared in their canonical
+	// form, as providers may serve them written differently, e.g. with a trailing dot or quoted
+	if !rdata.Equal(record.Type, domain, dnsRecord.Value, record.Value) {
+		log.WithFields(log.Fields{
+			"Name": record.Name,
+		}).Debug("Existing record found with old data, updating")
+		result.Action = report.Update
+		err = handler.UpdateRecord(ctx, domain, record)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":  err,
+				"Record": record.Name,
+			}).Error("Failed to update DNS record")
+			result.Outcome = report.Failed
+			result.Error = err.Error()
+			return
+		}
+		log.WithFields(log.Fields{
+			"Record": record.Name,
+		}).Info("Successfully updated DNS record")
+		result.Outcome = report.Updated
+		return
+	}
+	log.WithFields(log.Fields{
+		"Name":  record.Name,
+		"Value": record.Value,
+		"DNS":   dnsRecord.Value,
+	}).Debug("Correct record already exists, nothing to do")
+	result.Outcome = report.Unchanged
+}
+
+// renderValue evaluates the template of a record value, checking the rendered value against the record type
+func renderValue(domain string, record models.DNSRecord, f *facts.Facts) (string, error) {
+	value, err := facts.Render(record.Value, f)
+	if err != nil {
+		return "", fmt.Errorf("invalid value template: %s", err)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("value template rendered an empty value")
+	}
+	if _, err := rdata.Normalize(record.Type, domain, value); err != nil {
+		return "", fmt.Errorf("rendered %s", err)
+	}
+	return value, nil
+}
+
+// processDomain processes all the records of a domain. When handler is nil,
+// or the execution was interrupted, records are reported as skipped with the given reason
+func processDomain(ctx context.Context, provider config.ProviderConfiguration, d config.DomainConfiguration, handler models.Provider, f *facts.Facts, skipReason error, r *report.Report) {
+	capabilities := api.ProviderCapabilities(provider.Name)
+	// Names and types already processed, a provider without multi-value records can hold one of each only
+	seen := make(map[string]bool)
+	for _, record := range d.Records {
+		key := strings.ToLower(record.Type + " " + record.Name)
+		duplicate := seen[key]
+		seen[key] = true
+		result := report.RecordResult{
+			Provider: provider.Name,
+			Account:  provider.ID,
+			Domain:   d.Domain,
+			Name:     record.Name,
+			Type:     record.Type,
+		}
+		// Stop early if the execution was cancelled or ran out of time
+		if skipReason == nil && ctx.Err() != nil {
+			skipReason = ctx.Err()
+		}
+		if skipReason != nil {
+			result.Outcome = report.Skipped
+			result.Error = skipReason.Error()
+		} else if !capabilities.Supports(record.Type) {
+			result.Outcome = report.Failed
+			result.Error = fmt.Sprintf("%s records are not supported by %s", record.Type, provider.Name)
+		} else if duplicate && !capabilities.MultiValue {
+			result.Outcome = report.Failed
+			result.Error = fmt.Sprintf("%s record %s already has a value, %s does not support several values per name", record.Type, record.Name, provider.Name)
+		} else {
+			// Apply the TTL the provider would silently set anyway, so that the change is visible
+			if ttl := capabilities.ClampTTL(record.TTL); ttl != record.TTL {
+				warning := fmt.Sprintf("ttl %d is out of the range accepted by %s, %d is used", record.TTL, provider.Name, ttl)
+				log.WithFields(log.Fields{
+					"Record":   record.Name,
+					"Provider": provider.Name,
+				}).Warn(warning)
+				result.Warnings = append(result.Warnings, warning)
+				record.TTL = ttl
+			}
+			processRecord(ctx, d.Domain, record, handler, f, &result)
+		}
+		r.Add(result)
+	}
+}
+
+// verifyPropagation waits for the records changed by the execution to be served by their authoritative nameservers
+func verifyPropagation(ctx context.Context, verifier *propagation.Verifier, r *report.Report) {
+	var wg sync.WaitGroup
+	for i := range r.Results {
+		result := &r.Results[i]
+		if result.Outcome != report.Created && result.Outcome != report.Updated {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result.Propagation = verifier.Verify(ctx, result.Domain, result.Name, result.Type, result.Desired)
+		}()
+	}
+	wg.Wait()
+}
+
+// newFacts returns the facts of an execution, replaced in tests
+var newFacts = facts.New
+
+// reconciled reports whether any record of the execution is up-to-date
+func reconciled(r *report.Report) bool {
+	for _, result := range r.Results {
+		if result.Outcome == report.Created || result.Outcome == report.Updated || result.Outcome == report.Unchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Run reconciles the configured records selected by the scope with their provider and reports the outcome for each of them
+func Run(ctx context.Context, c config.Config, scope Scope) *report.Report {
+	r := report.New()
+	defer func() {
+		r.Finish()
+		metrics.ObserveReport(r)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Run)
+	defer cancel()
+	// Facts are discovered once for the whole execution, the public IP only when a record uses it
+	f := newFacts(ctx, "", c.IPSources, c.Timeouts.Call)
+	// Process providers one by one
+	for _, provider := range c.Providers {
+		domains := make([]config.DomainConfiguration, 0, len(provider.Domains))
+		for _, domain := range provider.Domains {
+			if scope.Includes(provider, domain.Domain, c.DomainSchedule(provider, domain).String()) {
+				domains = append(domains, domain)
+			}
+		}
+		if len(domains) == 0 {
+			continue
+		}
+		// Build a dedicated handler for each configured account using the provider registry
+		handler, err := api.NewProvider(provider.Name, api.Options{
+			ClientID:  provider.ClientID,
+			ClientKey: provider.ClientKey,
+			Timeout:   c.Timeouts.Call,
+			Retry:     provider.Retry,
+			Instrument: func(handler models.Provider) models.Provider {
+				return metrics.InstrumentProvider(handler, provider.Name, provider.ID)
+			},
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":    err,
+				"Provider": provider.Name,
+				"Account":  provider.ID,
+			}).Error("Failed to initialize provider")
+		} else {
+			log.WithFields(log.Fields{
+				"Domains":  len(domains),
+				"Provider": provider.Name,
+				"Account":  provider.ID,
+			}).Debug("Processing domains for provider")
+		}
+		for _, domain := range domains {
+			processDomain(ctx, provider, domain, handler, f, err, r)
+		}
+	}
+	if c.Propagation.Enabled {
+		verifyPropagation(ctx, propagation.NewVerifier(c.Propagation), r)
+	}
+	// Hosts without IPv6 connectivity, or whose records do not use the public IP, simply leave it unset
+	r.ExternalIP, _ = f.Discovered(true)
+	r.ExternalIPv6, _ = f.Discovered(false)
+	// The execution failed as a whole when the public IP was needed but unknown and no record could be brought up-to-date
+	if !reconciled(r) {
+		for _, v4 := range []bool{true, false} {
+			if _, err := f.Discovered(v4); err != nil {
+				r.DiscoveryError = err.Error()
+				break
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/config"
+	"github.com/sudneo/home-ddns/facts"
+	"github.com/sudneo/home-ddns/models"
+	"github.com/sudneo/home-ddns/report"
+)
+
+// stubProvider serves fixed records and remembers the ones written
+type stubProvider struct {
+	records map[string]models.DNSRecord
+	written []models.DNSRecord
+}
+
+func (p *stubProvider) GetRecord(_ context.Context, _ string, record models.DNSRecord) (models.DNSRecord, error) {
+	return p.records[record.Type+" "+record.Name], nil
+}
+
+func (p *stubProvider) SetRecord(_ context.Context, _ string, record models.DNSRecord) error {
+	p.written = append(p.written, record)
+	return nil
+}
+
+func (p *stubProvider) UpdateRecord(_ context.Context, _ string, record models.DNSRecord) error {
+	p.written = append(p.written, record)
+	return nil
+}
+
+func TestProcessDomain(t *testing.T) {
+	handler := &stubProvider{records: map[string]models.DNSRecord{
+		"AAAA nas":  {Value: "2001:DB8:0::1"},
+		"CNAME www": {Value: "Example.com."},
+		"TXT @":     {Value: `"v=spf1 -all"`},
+	}}
+	domain := config.DomainConfiguration{Domain: "example.com", Records: []models.DNSRecord{
+		{Name: "home", Type: "A", TTL: 60},
+		{Name: "nas", Type: "AAAA", Value: "2001:db8::1"},
+		{Name: "www", Type: "CNAME"},
+		{Name: "@", Type: "TXT", Value: "v=spf1 -all"},
+		{Name: "vpn", Type: "A", Value: "{{.Env \"UNSET_HOME_DDNS_VARIABLE\"}}"},
+	}}
+	provider := config.ProviderConfiguration{Name: api.PorkbunProvider, ID: api.PorkbunProvider}
+	r := report.New()
+	f := facts.New(context.Background(), "192.0.2.1", nil, time.Second)
+	processDomain(context.Background(), provider, domain, handler, f, nil, r)
+
+	expected := []report.Outcome{report.Created, report.Unchanged, report.Unchanged, report.Unchanged, report.Failed}
+	for i, result := range r.Results {
+		if result.Outcome != expected[i] {
+			t.Errorf("Expected %s %s to be %s, got %s: %s", result.Type, result.Name, expected[i], result.Outcome, result.Error)
+		}
+	}
+	// The TTL below the minimum of Porkbun is raised with a warning
+	if len(handler.written) != 1 || handler.written[0].TTL != 3600 || len(r.Results[0].Warnings) != 1 {
+		t.Errorf("TTL not clamped: %+v %+v", handler.written, r.Results[0])
+	}
+	if !strings.Contains(r.Results[4].Error, "UNSET_HOME_DDNS_VARIABLE is not set") {
+		t.Errorf("Unexpected template error %q", r.Results[4].Error)
+	}
+
+	// Records without value take the public IP of their family, and fail when it is unknown
+	r = report.New()
+	handler.written = nil
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	v4Only := facts.New(cancelled, "192.0.2.1", nil, time.Second)
+	processDomain(context.Background(), provider, config.DomainConfiguration{Domain: "example.com", Records: []models.DNSRecord{
+		{Name: "v6", Type: "AAAA"},
+	}}, handler, v4Only, nil, r)
+	if r.Results[0].Outcome != report.Failed || len(handler.written) != 0 {
+		t.Errorf("AAAA record without IPv6 not failed: %+v %+v", r.Results[0], handler.written)
+	}
+	r = report.New()
+	processDomain(context.Background(), provider, config.DomainConfiguration{Domain: "example.com", Records: []models.DNSRecord{
+		{Name: "v6", Type: "AAAA"},
+	}}, handler, facts.New(context.Background(), "2001:db8::5", nil, time.Second), nil, r)
+	if r.Results[0].Outcome != report.Created || r.Results[0].Desired != "2001:db8::5" {
+		t.Errorf("AAAA record without value did not take the public IPv6: %+v", r.Results[0])
+	}
+
+	// Types the provider does not support are rejected without calling it
+	previous := api.ProviderCapabilities(api.PorkbunProvider)
+	defer api.RegisterCapabilities(api.PorkbunProvider, previous)
+	api.RegisterCapabilities(api.PorkbunProvider, api.Capabilities{Types: []string{"A"}})
+	r = report.New()
+	handler.written = nil
+	processDomain(context.Background(), provider, config.DomainConfiguration{Domain: "example.com", Records: []models.DNSRecord{
+		{Name: "@", Type: "CAA", Value: `0 issue "letsencrypt.org"`},
+	}}, handler, f, nil, r)
+	if r.Results[0].Outcome != report.Failed || len(handler.written) != 0 {
+		t.Errorf("Unsupported type not rejected: %+v", r.Results[0])
+	}
+
+	// A second value of a name is rejected by providers without multi-value records
+	api.RegisterCapabilities(api.PorkbunProvider, api.Capabilities{Types: []string{"A"}})
+	r = report.New()
+	processDomain(context.Background(), provider, config.DomainConfiguration{Domain: "example.com", Records: []models.DNSRecord{
+		{Name: "lb", Type: "A", Value: "192.0.2.1"},
+		{Name: "LB", Type: "A", Value: "192.0.2.2"},
+	}}, handler, f, nil, r)
+	if r.Results[0].Outcome != report.Created || r.Results[1].Outcome != report.Failed || len(handler.written) != 1 {
+		t.Errorf("Second value not rejected: %+v", r.Results)
+	}
+	api.RegisterCapabilities(api.PorkbunProvider, api.Capabilities{Types: []string{"A"}, MultiValue: true})
+	r = report.New()
+	handler.written = nil
+	processDomain(context.Background(), provider, config.DomainConfiguration{Domain: "example.com", Records: []models.DNSRecord{
+		{Name: "lb", Type: "A", Value: "192.0.2.1"},
+		{Name: "lb", Type: "A", Value: "192.0.2.2"},
+	}}, handler, f, nil, r)
+	if r.Results[1].Outcome == report.Failed || len(handler.written) != 2 {
+		t.Errorf("Second value rejected by a multi-value provider: %+v", r.Results)
+	}
+}
+
+func TestRunWithoutPublicIP(t *testing.T) {
+	handler := &stubProvider{}
+	api.Register("Stub", func(api.Options) (models.Provider, error) {
+		return handler, nil
+	})
+	// Public IP lookups fail with the cancelled context, interface addresses are still read
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	defer func(previous func(context.Context, string, map[string]facts.Source, time.Duration) *facts.Facts) {
+		newFacts = previous
+	}(newFacts)
+	newFacts = func(_ context.Context, publicIP string, sources map[string]facts.Source, timeout time.Duration) *facts.Facts {
+		return facts.New(cancelled, publicIP, sources, timeout)
+	}
+	c := config.Config{
+		IPSources: map[string]facts.Source{"lan": {Type: facts.InterfaceSource, Interface: "lo"}},
+		Timeouts:  config.TimeoutConfiguration{Call: time.Second, Run: time.Minute},
+		Providers: []config.ProviderConfiguration{{Name: "Stub", ID: "Stub", Domains: []config.DomainConfiguration{
+			{Domain: "example.com", Records: []models.DNSRecord{{Name: "lan", Type: "A", Source: "lan"}}},
+		}}},
+	}
+	r := Run(context.Background(), c, Scope{})
+	if r.Status() != report.Success || r.ExternalIP != "" || len(handler.written) != 1 || handler.written[0].Value != "127.0.0.1" {
+		t.Errorf("Record of an interface source not set without the public IP: %+v %+v", r, handler.written)
+	}
+
+	// Records using the public IP fail, the execution too when none could be brought up-to-date
+	c.Providers[0].Domains[0].Records = append(c.Providers[0].Domains[0].Records, models.DNSRecord{Name: "home", Type: "A"})
+	r = Run(context.Background(), c, Scope{})
+	if r.Status() != report.PartialFailure || r.Results[1].Outcome != report.Failed {
+		t.Errorf("Record of the public IP not failed alone: %s %+v", r.Status(), r.Results)
+	}
+	c.Providers[0].Domains[0].Records = c.Providers[0].Domains[0].Records[1:]
+	r = Run(context.Background(), c, Scope{})
+	if r.Status() != report.DiscoveryFailure || r.DiscoveryError == "" {
+		t.Errorf("Execution without the public IP not failed: %s %+v", r.Status(), r.Results)
+	}
+}
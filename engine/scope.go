@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"github.com/sudneo/home-ddns/config"
+)
+
+// Target selects the records of a provider, matched by account id or provider name,
+// optionally limited to a single domain and to the domain blocks following a given schedule
+type Target struct {
+	Provider string `json:"provider,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// Scope limits an execution to a set of targets. An empty scope selects every record
+type Scope struct {
+	Targets []Target `json:"targets,omitempty"`
+}
+
+// All reports whether the scope selects every record
+func (s Scope) All() bool {
+	return len(s.Targets) == 0
+}
+
+func (t Target) matches(provider config.ProviderConfiguration, domain string, schedule string) bool {
+	if t.Provider != "" && t.Provider != provider.ID && t.Provider != provider.Name {
+		return false
+	}
+	if t.Schedule != "" && t.Schedule != schedule {
+		return false
+	}
+	return t.Domain == "" || t.Domain == domain
+}
+
+// Includes reports whether the records of a domain block, following the given schedule, are selected
+func (s Scope) Includes(provider config.ProviderConfiguration, domain string, schedule string) bool {
+	if s.All() {
+		return true
+	}
+	for _, target := range s.Targets {
+		if target.matches(provider, domain, schedule) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns a scope selecting the records of both scopes
+func (s Scope) Merge(other Scope) Scope {
+	if s.All() || other.All() {
+		return Scope{}
+	}
+	merged := Scope{Targets: append([]Target(nil), s.Targets...)}
+	for _, target := range other.Targets {
+		if target == (Target{}) {
+			return Scope{}
+		}
+		duplicate := false
+		for _, existing := range merged.Targets {
+			if existing == target {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged.Targets = append(merged.Targets, target)
+		}
+	}
+	return merged
+}
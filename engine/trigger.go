@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Trigger requests executions outside of the schedule. Requests fired while
+// an execution is pending are merged, so that they result in a single execution
+type Trigger struct {
+	mu      sync.Mutex
+	pending *Scope
+	c       chan struct{}
+}
+
+func NewTrigger() *Trigger {
+	return &Trigger{c: make(chan struct{}, 1)}
+}
+
+// Fire requests an execution for the given scope, without blocking
+func (t *Trigger) Fire(scope Scope) {
+	t.mu.Lock()
+	if t.pending == nil {
+		t.pending = &scope
+	} else {
+		merged := t.pending.Merge(scope)
+		t.pending = &merged
+	}
+	t.mu.Unlock()
+	select {
+	case t.c <- struct{}{}:
+	default:
+	}
+}
+
+// C is notified whenever an execution is requested
+func (t *Trigger) C() <-chan struct{} {
+	return t.c
+}
+
+// Take returns the scope of all the pending requests and clears them.
+// The boolean is false when there was no pending request
+func (t *Trigger) Take() (Scope, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		return Scope{}, false
+	}
+	scope := *t.pending
+	t.pending = nil
+	return scope, true
+}
+
+// Handler serves POST requests firing the trigger, authenticated with a bearer token compared in constant time.
+// Every request is refused when the token is empty. The optional provider and domain query parameters limit the execution
+func (t *Trigger) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		authorization := r.Header.Get("Authorization")
+		supplied := strings.TrimPrefix(authorization, "Bearer ")
+		if token == "" || supplied == authorization || subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var scope Scope
+		target := Target{Provider: r.URL.Query().Get("provider"), Domain: r.URL.Query().Get("domain")}
+		if target.Provider != "" || target.Domain != "" {
+			scope.Targets = []Target{target}
+		}
+		log.WithFields(log.Fields{
+			"Provider": target.Provider,
+			"Domain":   target.Domain,
+		}).Info("Execution triggered through HTTP")
+		t.Fire(scope)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("triggered\n"))
+	})
+}
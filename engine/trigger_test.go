@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sudneo/home-ddns/config"
+)
+
+func TestTriggerMerge(t *testing.T) {
+	trigger := NewTrigger()
+	trigger.Fire(Scope{Targets: []Target{{Provider: "personal"}}})
+	trigger.Fire(Scope{Targets: []Target{{Domain: "example.com"}}})
+	trigger.Fire(Scope{Targets: []Target{{Provider: "personal"}}})
+	select {
+	case <-trigger.C():
+	default:
+		t.Fatalf("Trigger did not notify")
+	}
+	select {
+	case <-trigger.C():
+		t.Errorf("Concurrent triggers were not merged")
+	default:
+	}
+	scope, ok := trigger.Take()
+	if !ok || len(scope.Targets) != 2 {
+		t.Errorf("Expected 2 merged targets, got %+v", scope.Targets)
+	}
+	provider := config.ProviderConfiguration{ID: "personal", Name: "Godaddy"}
+	other := config.ProviderConfiguration{ID: "work", Name: "Porkbun"}
+	if !scope.Includes(provider, "example.org", "@hourly") || !scope.Includes(other, "example.com", "@hourly") || scope.Includes(other, "example.org", "@hourly") {
+		t.Errorf("Merged scope selects the wrong domains")
+	}
+	trigger.Fire(Scope{Targets: []Target{{Provider: "personal"}}})
+	trigger.Fire(Scope{})
+	if scope, _ := trigger.Take(); !scope.All() {
+		t.Errorf("Merging with an unscoped trigger did not select every record")
+	}
+	if _, ok := trigger.Take(); ok {
+		t.Errorf("Take did not clear the pending requests")
+	}
+	scheduled := Scope{Targets: []Target{{Provider: "personal", Domain: "example.com", Schedule: "@daily"}}}
+	if scheduled.Includes(provider, "example.com", "@hourly") || !scheduled.Includes(provider, "example.com", "@daily") {
+		t.Errorf("Scope does not tell apart domain blocks with different schedules")
+	}
+}
+
+func TestTriggerHandler(t *testing.T) {
+	trigger := NewTrigger()
+	handler := trigger.Handler("secret")
+	cases := []struct {
+		method        string
+		authorization string
+		code          int
+	}{
+		{"GET", "Bearer secret", http.StatusMethodNotAllowed},
+		{"POST", "", http.StatusUnauthorized},
+		{"POST", "Bearer wrong", http.StatusUnauthorized},
+		{"POST", "secret", http.StatusUnauthorized},
+		{"POST", "Basic secret", http.StatusUnauthorized},
+		{"POST", "Bearer secret", http.StatusAccepted},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/trigger?provider=Godaddy&domain=example.com", nil)
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != c.code {
+			t.Errorf("%s with authorization %q: expected %d, got %d", c.method, c.authorization, c.code, w.Code)
+		}
+	}
+	scope, _ := trigger.Take()
+	if len(scope.Targets) != 1 || scope.Targets[0] != (Target{Provider: "Godaddy", Domain: "example.com"}) {
+		t.Errorf("Scope not taken from the query: %+v", scope)
+	}
+	// Without a token configured the endpoint is disabled
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/trigger", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	NewTrigger().Handler("").ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Trigger without token configured accepted a request")
+	}
+}
@@ -0,0 +1,318 @@
+package facts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sudneo/home-ddns/utils"
+)
+
+// Length of the IPv6 prefix delegated to a home network
+const prefixLength = 64
+
+// Facts discovered on the host, available to the templates of the record values, e.g. {{.IPv4}}.
+// Each fact is discovered the first time a template uses it, then cached for the rest of the execution
+type Facts struct {
+	ctx     context.Context
+	timeout time.Duration
+	// Named sources of the record addresses
+	sources map[string]Source
+	// Sources of the facts, replaced in tests and validation
+	publicIP       func(ctx context.Context, network string, service string, iface string) (string, error)
+	interfaceAddrs func(name string) ([]net.IP, error)
+	hostname       func() (string, error)
+	lookupEnv      func(name string) (string, bool)
+	mu             sync.Mutex
+	cache          map[string]fact
+}
+
+type fact struct {
+	value interface{}
+	err   error
+}
+
+// New returns the facts of an execution. The public IP already discovered is used as IPv4 or IPv6 fact,
+// every other discovery is bound by the timeout
+func New(ctx context.Context, publicIP string, sources map[string]Source, timeout time.Duration) *Facts {
+	f := &Facts{
+		ctx:            ctx,
+		timeout:        timeout,
+		sources:        sources,
+		publicIP:       utils.GetPublicIPVia,
+		interfaceAddrs: utils.InterfaceAddrs,
+		hostname:       os.Hostname,
+		lookupEnv:      os.LookupEnv,
+		cache:          make(map[string]fact),
+	}
+	if ip := net.ParseIP(publicIP); ip != nil {
+		if ip.To4() != nil {
+			f.cache["tcp4"] = fact{value: publicIP}
+		} else {
+			f.cache["tcp6"] = fact{value: publicIP}
+		}
+	}
+	return f
+}
+
+// dry returns facts with fixed values, which can check templates without discovering anything
+func dry(sources map[string]Source) *Facts {
+	f := New(context.Background(), "", sources, time.Second)
+	f.publicIP = func(_ context.Context, network string, _ string, _ string) (string, error) {
+		if network == "tcp6" {
+			return "2001:db8::1", nil
+		}
+		return "192.0.2.1", nil
+	}
+	f.interfaceAddrs = func(string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}, nil
+	}
+	f.hostname = func() (string, error) {
+		return "host", nil
+	}
+	f.lookupEnv = func(string) (string, bool) {
+		return "", true
+	}
+	return f
+}
+
+// get returns a fact, discovering it the first time
+func (f *Facts) get(key string, discover func() (interface{}, error)) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cached, ok := f.cache[key]; ok {
+		return cached.value, cached.err
+	}
+	value, err := discover()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+			"Fact":  key,
+		}).Debug("Failed to discover fact")
+	}
+	f.cache[key] = fact{value: value, err: err}
+	return value, err
+}
+
+// publicAddress discovers the public IP of a family, network is tcp4 or tcp6.
+// The service and interface of the lookup are optional, the default lookup is cached with the network as key
+func (f *Facts) publicAddress(network string, v4 bool, service string, iface string) (string, error) {
+	key := network
+	if service != "" || iface != "" {
+		key = strings.Join([]string{network, service, iface}, " ")
+	}
+	value, err := f.get(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(f.ctx, f.timeout)
+		defer cancel()
+		address, err := f.publicIP(ctx, network, service, iface)
+		if err != nil {
+			return "", err
+		}
+		if ip := net.ParseIP(address); ip == nil || (ip.To4() != nil) != v4 {
+			return "", fmt.Errorf("unexpected public IP %q", address)
+		}
+		return address, nil
+	})
+	return value.(string), err
+}
+
+// IPv4 is the public IPv4 address
+func (f *Facts) IPv4() (string, error) {
+	return f.publicAddress("tcp4", true, "", "")
+}
+
+// IPv6 is the public IPv6 address
+func (f *Facts) IPv6() (string, error) {
+	return f.publicAddress("tcp6", false, "", "")
+}
+
+// Discovered returns the public IP of a family and the error of its discovery, both empty when it was never needed
+func (f *Facts) Discovered(v4 bool) (string, error) {
+	key := "tcp6"
+	if v4 {
+		key = "tcp4"
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cached := f.cache[key]
+	address, _ := cached.value.(string)
+	return address, cached.err
+}
+
+// Prefix is the /64 network of the public IPv6 address, e.g. 2001:db8:1:2::/64
+func (f *Facts) Prefix() (string, error) {
+	ip, err := f.IPv6()
+	if err != nil {
+		return "", err
+	}
+	return network(ip, prefixLength)
+}
+
+// Hostname is the name of the host
+func (f *Facts) Hostname() (string, error) {
+	value, err := f.get("hostname", func() (interface{}, error) {
+		return f.hostname()
+	})
+	return value.(string), err
+}
+
+// Interface returns the addresses of a network interface
+func (f *Facts) Interface(name string) ([]string, error) {
+	value, err := f.get("interface "+name, func() (interface{}, error) {
+		ips, err := f.interfaceAddrs(name)
+		if err != nil {
+			return []string(nil), err
+		}
+		addresses := make([]string, len(ips))
+		for i, ip := range ips {
+			addresses[i] = ip.String()
+		}
+		return addresses, nil
+	})
+	return value.([]string), err
+}
+
+// interfaceAddress returns the first address of an interface in a family, preferring global ones
+func (f *Facts) interfaceAddress(name string, v4 bool) (string, error) {
+	addresses, err := f.Interface(name)
+	if err != nil {
+		return "", err
+	}
+	var fallback string
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if (ip.To4() != nil) != v4 {
+			continue
+		}
+		if ip.IsGlobalUnicast() {
+			return address, nil
+		}
+		if fallback == "" {
+			fallback = address
+		}
+	}
+	if fallback == "" {
+		family := "IPv6"
+		if v4 {
+			family = "IPv4"
+		}
+		return "", fmt.Errorf("no %s address on interface %s", family, name)
+	}
+	return fallback, nil
+}
+
+// InterfaceIPv4 returns the IPv4 address of a network interface
+func (f *Facts) InterfaceIPv4(name string) (string, error) {
+	return f.interfaceAddress(name, true)
+}
+
+// InterfaceIPv6 returns the IPv6 address of a network interface, global addresses are preferred to link-local ones
+func (f *Facts) InterfaceIPv6(name string) (string, error) {
+	return f.interfaceAddress(name, false)
+}
+
+// Env returns an environment variable, failing if it is not set
+func (f *Facts) Env(name string) (string, error) {
+	value, ok := f.lookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// network returns the network of an address with the given prefix length, e.g. 2001:db8::/64
+func network(address string, bits int) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", address)
+	}
+	size := 128
+	if ip.To4() != nil {
+		ip, size = ip.To4(), 32
+	}
+	if bits < 0 || bits > size {
+		return "", fmt.Errorf("invalid prefix length %d for %s", bits, address)
+	}
+	n := net.IPNet{IP: ip.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}
+	return n.String(), nil
+}
+
+// host combines a network with the host part of an address, e.g. 2001:db8:1:2::/64 and ::10 give 2001:db8:1:2::10
+func host(prefix string, suffix string) (string, error) {
+	_, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", fmt.Errorf("invalid network %q", prefix)
+	}
+	id := net.ParseIP(suffix)
+	if id == nil {
+		return "", fmt.Errorf("invalid host address %q", suffix)
+	}
+	if v4 := n.IP.To4(); v4 != nil {
+		if id = id.To4(); id == nil {
+			return "", fmt.Errorf("host address %q is not an IPv4 address", suffix)
+		}
+		n.IP = v4
+	}
+	ip := make(net.IP, len(n.IP))
+	for i := range ip {
+		ip[i] = n.IP[i] | (id[i] &^ n.Mask[i])
+	}
+	return ip.String(), nil
+}
+
+// Helper functions available to the templates
+var funcs = template.FuncMap{
+	"network": network,
+	"host":    host,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old string, new string, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"trimPrefix": func(prefix string, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"trimSuffix": func(suffix string, s string) string {
+		return strings.TrimSuffix(s, suffix)
+	},
+	"join": func(separator string, items []string) string {
+		return strings.Join(items, separator)
+	},
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// IsTemplate reports whether a value is a template rather than a literal
+func IsTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// Render evaluates the template of a value with the facts
+func Render(value string, f *Facts) (string, error) {
+	t, err := template.New("value").Funcs(funcs).Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, f); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Check validates the template of a value, evaluating it with placeholder facts and the configured sources
+func Check(value string, sources map[string]Source) error {
+	_, err := Render(value, dry(sources))
+	return err
+}
@@ -0,0 +1,166 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stub returns facts discovered from fixed sources, counting the public IP lookups
+func stub(publicIP string, lookups *int) *Facts {
+	sources := map[string]Source{
+		"vpn":  {Type: InterfaceSource, Interface: "eth0"},
+		"wan2": {Interface: "ppp1"},
+	}
+	f := New(context.Background(), publicIP, sources, time.Second)
+	f.publicIP = func(_ context.Context, network string, _ string, iface string) (string, error) {
+		*lookups++
+		if iface == "ppp1" {
+			return "203.0.113.5", nil
+		}
+		if network == "tcp6" {
+			return "2001:db8:1:2:aaaa::1", nil
+		}
+		return "", errors.New("no IPv4 connectivity")
+	}
+	f.interfaceAddrs = func(name string) ([]net.IP, error) {
+		if name != "eth0" {
+			return nil, errors.New("no such network interface")
+		}
+		return []net.IP{net.ParseIP("fe80::1"), net.ParseIP("10.0.0.2"), net.ParseIP("2001:db8::2")}, nil
+	}
+	f.hostname = func() (string, error) {
+		return "Router", nil
+	}
+	f.lookupEnv = func(name string) (string, bool) {
+		if name == "SITE" {
+			return "home", true
+		}
+		return "", false
+	}
+	return f
+}
+
+func TestRender(t *testing.T) {
+	lookups := 0
+	f := stub("198.51.100.7", &lookups)
+	rendered := map[string]string{
+		"v=spf1 ip4:{{.IPv4}} -all":                     "v=spf1 ip4:198.51.100.7 -all",
+		"{{.IPv6}}":                                     "2001:db8:1:2:aaaa::1",
+		"{{.Prefix}}":                                   "2001:db8:1:2::/64",
+		`{{host .Prefix "::10"}}`:                       "2001:db8:1:2::10",
+		`{{network .IPv4 24}}`:                          "198.51.100.0/24",
+		`{{host (network .IPv4 24) "0.0.0.9"}}`:         "198.51.100.9",
+		"{{lower .Hostname}}":                           "router",
+		`{{.InterfaceIPv4 "eth0"}}`:                     "10.0.0.2",
+		`{{.InterfaceIPv6 "eth0"}}`:                     "2001:db8::2",
+		`{{join "," (.Interface "eth0")}}`:              "fe80::1,10.0.0.2,2001:db8::2",
+		`{{.Env "SITE"}}.example.com`:                   "home.example.com",
+		`{{replace "-" "." (trimSuffix "-x" "a-b-x")}}`: "a.b",
+		`{{default "none" ""}}`:                         "none",
+		"plain":                                         "plain",
+	}
+	for template, expected := range rendered {
+		value, err := Render(template, f)
+		if err != nil {
+			t.Errorf("Rendering %q lead to error: %s", template, err)
+		} else if value != expected {
+			t.Errorf("Rendering %q gave %q, expected %q", template, value, expected)
+		}
+	}
+	// The public IPv6 is discovered once, the IPv4 one was already known
+	if lookups != 1 {
+		t.Errorf("Expected a single public IP lookup, got %d", lookups)
+	}
+
+	failing := map[string]string{
+		`{{.Env "MISSING"}}`:          "environment variable MISSING is not set",
+		`{{.InterfaceIPv4 "wlan0"}}`:  "no such network interface",
+		`{{host "10.0.0.0/8" "::1"}}`: "is not an IPv4 address",
+		"{{.Unknown}}":                "can't evaluate field Unknown",
+	}
+	for template, expected := range failing {
+		if _, err := Render(template, f); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q rendering %q, got %v", expected, template, err)
+		}
+	}
+
+	// Failed discoveries are cached too
+	f = stub("", &lookups)
+	lookups = 0
+	for i := 0; i < 2; i++ {
+		if _, err := Render("{{.IPv4}}", f); err == nil {
+			t.Errorf("Expected the IPv4 discovery to fail")
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected a single public IP lookup, got %d", lookups)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(`{{host .Prefix "::10"}} {{.Env "ANY"}} {{.InterfaceIPv4 "eth0"}}`, nil); err != nil {
+		t.Errorf("Valid template lead to error: %s", err)
+	}
+	for _, template := range []string{"{{.IPv4", "{{.Address}}", "{{unknown .IPv4}}"} {
+		if err := Check(template, nil); err == nil {
+			t.Errorf("Invalid template %q accepted", template)
+		}
+	}
+	if IsTemplate("192.0.2.1") || !IsTemplate("{{.IPv4}}") {
+		t.Errorf("Unexpected template detection")
+	}
+}
+
+func TestSources(t *testing.T) {
+	lookups := 0
+	f := stub("198.51.100.7", &lookups)
+	addresses := []struct {
+		source   string
+		v4       bool
+		expected string
+	}{
+		{"", true, "198.51.100.7"},
+		{"vpn", true, "10.0.0.2"},
+		{"vpn", false, "2001:db8::2"},
+		{"wan2", true, "203.0.113.5"},
+		{"wan2", true, "203.0.113.5"},
+	}
+	for _, a := range addresses {
+		address, err := f.Address(a.source, a.v4)
+		if err != nil {
+			t.Errorf("Address of source %q lead to error: %s", a.source, err)
+		} else if address != a.expected {
+			t.Errorf("Address of source %q is %s, expected %s", a.source, address, a.expected)
+		}
+	}
+	// The secondary WAN is discovered once, the default one was already known
+	if lookups != 1 {
+		t.Errorf("Expected a single public IP lookup, got %d", lookups)
+	}
+	if _, err := f.Address("lte", true); err == nil || !strings.Contains(err.Error(), "expected one of vpn, wan2") {
+		t.Errorf("Expected an unknown source error, got %v", err)
+	}
+	if value, err := Render(`{{.SourceIPv4 "wan2"}}`, f); err != nil || value != "203.0.113.5" {
+		t.Errorf("Unexpected source template rendering %q, %v", value, err)
+	}
+	if err := Check(`{{.SourceIPv6 "vpn"}}`, nil); err == nil {
+		t.Errorf("Template with an unknown source accepted")
+	}
+
+	for source, valid := range map[Source]bool{
+		{}:                             true,
+		{URL: "https://api.ipify.org"}: true,
+		{Type: InterfaceSource, Interface: "wg0"}: true,
+		{Type: InterfaceSource}:                   false,
+		{URL: "ifconfig.io"}:                      false,
+		{Type: "dhcp"}:                            false,
+	} {
+		if err := source.Validate(); (err == nil) != valid {
+			t.Errorf("Expected validity of %+v to be %v, got %v", source, valid, err)
+		}
+	}
+}
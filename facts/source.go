@@ -0,0 +1,99 @@
+package facts
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// The address seen by an IP lookup service
+	PublicSource = "public"
+	// An address of a network interface of the host
+	InterfaceSource = "interface"
+)
+
+// Source of the address of the records referencing it by name, e.g. a VPN interface or a secondary WAN
+type Source struct {
+	// public (default) or interface
+	Type string `yaml:"type"`
+	// Network interface of the address. For public sources, the interface the lookup leaves from
+	Interface string `yaml:"interface"`
+	// Service returning the public IP as plain text, default http://ifconfig.io/ip
+	URL string `yaml:"url"`
+}
+
+// Validate checks the configuration
+func (s Source) Validate() error {
+	switch s.Type {
+	case "", PublicSource:
+		if s.URL == "" {
+			return nil
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q, expected an http or https URL", s.URL)
+		}
+	case InterfaceSource:
+		if s.Interface == "" {
+			return fmt.Errorf("interface is required for interface sources")
+		}
+		if s.URL != "" {
+			return fmt.Errorf("url only applies to public sources")
+		}
+	default:
+		return fmt.Errorf("unknown source type %q, expected %s or %s", s.Type, PublicSource, InterfaceSource)
+	}
+	return nil
+}
+
+// SourceNames returns the sorted names of the sources
+func SourceNames(sources map[string]Source) []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Address returns the IPv4 or IPv6 address of a named source, the public IP when the name is empty.
+// Each source is discovered the first time it is used
+func (f *Facts) Address(name string, v4 bool) (string, error) {
+	network := "tcp6"
+	if v4 {
+		network = "tcp4"
+	}
+	if name == "" {
+		return f.publicAddress(network, v4, "", "")
+	}
+	source, ok := f.sources[name]
+	if !ok {
+		if len(f.sources) == 0 {
+			return "", fmt.Errorf("unknown IP source %q, no ip_sources are configured", name)
+		}
+		return "", fmt.Errorf("unknown IP source %q, expected one of %s", name, strings.Join(SourceNames(f.sources), ", "))
+	}
+	var address string
+	var err error
+	if source.Type == InterfaceSource {
+		address, err = f.interfaceAddress(source.Interface, v4)
+	} else {
+		address, err = f.publicAddress(network, v4, source.URL, source.Interface)
+	}
+	if err != nil {
+		return "", fmt.Errorf("IP source %s: %s", name, err)
+	}
+	return address, nil
+}
+
+// SourceIPv4 returns the IPv4 address of a named source
+func (f *Facts) SourceIPv4(name string) (string, error) {
+	return f.Address(name, true)
+}
+
+// SourceIPv6 returns the IPv6 address of a named source
+func (f *Facts) SourceIPv6(name string) (string, error) {
+	return f.Address(name, false)
+}
@@ -1,9 +1,17 @@
 module github.com/sudneo/home-ddns
 
-go 1.16
+go 1.20
 
 require (
+	filippo.io/age v1.1.1
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/pelletier/go-toml/v2 v2.2.2
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.9.3
-	golang.org/x/sys v0.22.0 // indirect
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/net v0.27.0
+	golang.org/x/sys v0.22.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/crypto v0.25.0 // indirect
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var entriesBucket = []byte("entries")
+
+// Maximum time waited for the lock held by another process using the database
+const boltLockTimeout = 5 * time.Second
+
+// boltStore keeps the entries in an embedded bbolt database, keyed by a sequence number
+type boltStore struct {
+	path string
+}
+
+func (s *boltStore) open(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(s.path, 0600, &bolt.Options{Timeout: boltLockTimeout, ReadOnly: readOnly})
+}
+
+func (s *boltStore) Append(entries ...Entry) error {
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(entriesBucket)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := bucket.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Query(filter Filter) ([]Entry, error) {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	db, err := s.open(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var entries []Entry
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, data []byte) error {
+			var e Entry
+			if err := json.Unmarshal(data, &e); err != nil {
+				return err
+			}
+			if filter.Matches(e) {
+				entries = append(entries, e)
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
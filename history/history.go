@@ -0,0 +1,263 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sudneo/home-ddns/report"
+	"github.com/sudneo/home-ddns/utils"
+)
+
+// Kind of a history entry
+type Kind string
+
+const (
+	// The discovered public IP changed
+	IPChange Kind = "ip_change"
+	// A record was changed, or a change was attempted, at the provider
+	Mutation Kind = "mutation"
+)
+
+// Result of a mutation
+const (
+	Succeeded = "success"
+	Failed    = "failed"
+)
+
+// Entry is a single event of the history
+type Entry struct {
+	Time time.Time `json:"time"`
+	Kind Kind      `json:"kind"`
+	// Set for IP changes
+	Family string `json:"family,omitempty"`
+	// Set for mutations
+	Provider string        `json:"provider,omitempty"`
+	Account  string        `json:"account,omitempty"`
+	Domain   string        `json:"domain,omitempty"`
+	Name     string        `json:"name,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	Action   report.Action `json:"action,omitempty"`
+	Result   string        `json:"result,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Value before and after the change, Old is empty for the first IP and for created records
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// Record returns the fully qualified name of the record of a mutation
+func (e Entry) Record() string {
+	if e.Name == "" || e.Name == "@" {
+		return e.Domain
+	}
+	return e.Name + "." + e.Domain
+}
+
+// Filter selects history entries, zero values match everything
+type Filter struct {
+	// Either the record name or its fully qualified name, e.g. home or home.example.com
+	Record string
+	Domain string
+	Kind   Kind
+	Since  time.Time
+	Until  time.Time
+}
+
+// Matches returns whether an entry is selected by the filter
+func (f Filter) Matches(e Entry) bool {
+	if f.Kind != "" && e.Kind != f.Kind {
+		return false
+	}
+	if f.Record != "" && (e.Kind != Mutation || (e.Name != f.Record && !strings.EqualFold(e.Record(), strings.TrimSuffix(f.Record, ".")))) {
+		return false
+	}
+	if f.Domain != "" && (e.Kind != Mutation || !strings.EqualFold(e.Domain, f.Domain)) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Store persists the history. Entries are returned in the order they were appended
+type Store interface {
+	Append(entries ...Entry) error
+	Query(filter Filter) ([]Entry, error)
+}
+
+// Available backends
+const (
+	JSONLBackend = "jsonl"
+	BoltBackend  = "bolt"
+)
+
+// Configuration of the history store
+type Configuration struct {
+	// File holding the history, history is disabled when empty
+	Path string `yaml:"path"`
+	// jsonl or bolt, by default bolt for .db and .bolt files and jsonl otherwise
+	Backend string `yaml:"backend"`
+}
+
+// Enabled returns whether a history store is configured
+func (c Configuration) Enabled() bool {
+	return c.Path != ""
+}
+
+func (c Configuration) backend() string {
+	if c.Backend != "" {
+		return c.Backend
+	}
+	switch filepath.Ext(c.Path) {
+	case ".db", ".bolt":
+		return BoltBackend
+	}
+	return JSONLBackend
+}
+
+// Validate checks the configuration without opening the store
+func (c Configuration) Validate() error {
+	if c.Backend != "" && c.Backend != JSONLBackend && c.Backend != BoltBackend {
+		return fmt.Errorf("unknown history backend %q", c.Backend)
+	}
+	if c.Backend != "" && c.Path == "" {
+		return errors.New("history path is required")
+	}
+	return nil
+}
+
+// Open returns the store described by the configuration.
+// Files are only opened while entries are appended or queried, so the history can be
+// queried while the daemon is running
+func Open(c Configuration) (Store, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if !c.Enabled() {
+		return nil, errors.New("history path is required")
+	}
+	if c.backend() == BoltBackend {
+		return &boltStore{path: c.Path}, nil
+	}
+	return &jsonlStore{path: c.Path}, nil
+}
+
+// Recorder appends the IP changes and mutations of each execution to a store
+type Recorder struct {
+	store Store
+	mu    sync.Mutex
+	// Last known address of each IP family, loaded from the store on first use
+	ips map[string]string
+}
+
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{store: store}
+}
+
+func (rec *Recorder) loadIPs() error {
+	if rec.ips != nil {
+		return nil
+	}
+	entries, err := rec.store.Query(Filter{Kind: IPChange})
+	if err != nil {
+		return err
+	}
+	rec.ips = make(map[string]string)
+	for _, e := range entries {
+		rec.ips[e.Family] = e.New
+	}
+	return nil
+}
+
+// Observe records the IP transition and the mutations of an execution
+func (rec *Recorder) Observe(r *report.Report) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.loadIPs(); err != nil {
+		return err
+	}
+	var entries []Entry
+	addresses := []string{r.ExternalIP, r.ExternalIPv6}
+	for _, address := range addresses {
+		family := utils.IPFamily(address)
+		if address != "" && rec.ips[family] != address {
+			entries = append(entries, Entry{
+				Time:   r.Started,
+				Kind:   IPChange,
+				Family: family,
+				Old:    rec.ips[family],
+				New:    address,
+			})
+		}
+	}
+	for _, result := range r.Results {
+		if result.Action == "" {
+			continue
+		}
+		e := Entry{
+			Time:     r.Finished,
+			Kind:     Mutation,
+			Provider: result.Provider,
+			Account:  result.Account,
+			Domain:   result.Domain,
+			Name:     result.Name,
+			Type:     result.Type,
+			Action:   result.Action,
+			Result:   Succeeded,
+			Old:      result.Observed,
+			New:      result.Desired,
+		}
+		if result.Outcome == report.Failed {
+			e.Result = Failed
+			e.Error = result.Error
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := rec.store.Append(entries...); err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if address != "" {
+			rec.ips[utils.IPFamily(address)] = address
+		}
+	}
+	return nil
+}
+
+// WriteTable prints entries as a human readable table
+func WriteTable(w io.Writer, entries []Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tKIND\tACCOUNT\tRECORD\tTYPE\tACTION\tOLD\tNEW\tRESULT\tERROR")
+	for _, e := range entries {
+		record := e.Record()
+		if e.Kind == IPChange {
+			record = e.Family
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Kind, e.Account, record, e.Type, e.Action, e.Old, e.New, e.Result, e.Error)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON prints entries as JSON lines
+func WriteJSON(w io.Writer, entries []Entry) error {
+	encoder := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package history
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sudneo/home-ddns/report"
+)
+
+func execution(ip string, results ...report.RecordResult) *report.Report {
+	r := report.New()
+	r.ExternalIP = ip
+	for _, result := range results {
+		r.Add(result)
+	}
+	r.Finish()
+	return r
+}
+
+func testStore(t *testing.T, c Configuration) {
+	store, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewRecorder(store)
+	updated := report.RecordResult{Provider: "Godaddy", Account: "home", Domain: "example.com", Name: "www", Type: "A", Outcome: report.Updated, Action: report.Update, Observed: "192.0.2.1", Desired: "192.0.2.2"}
+	failed := report.RecordResult{Provider: "Godaddy", Account: "home", Domain: "example.org", Name: "@", Type: "A", Outcome: report.Failed, Action: report.Create, Desired: "192.0.2.2", Error: "API call failed"}
+	unchanged := report.RecordResult{Provider: "Godaddy", Account: "home", Domain: "example.com", Name: "www", Type: "A", Outcome: report.Unchanged, Observed: "192.0.2.2", Desired: "192.0.2.2"}
+	// Both families of a dual stack host are tracked
+	dualStack := execution("192.0.2.2")
+	dualStack.ExternalIPv6 = "2001:db8::1"
+	for _, r := range []*report.Report{
+		execution("192.0.2.2", updated, failed),
+		execution("192.0.2.2", unchanged),
+		dualStack,
+	} {
+		if err := recorder.Observe(r); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// A new recorder resumes from the IPs in the store
+	if err := NewRecorder(store).Observe(execution("192.0.2.3")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	entries, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("Expected 5 entries, got %+v", entries)
+	}
+	if e := entries[4]; e.Kind != IPChange || e.Old != "192.0.2.2" || e.New != "192.0.2.3" || e.Family != "ipv4" {
+		t.Errorf("Unexpected IP change %+v", e)
+	}
+	if e := entries[3]; e.Kind != IPChange || e.Old != "" || e.Family != "ipv6" {
+		t.Errorf("Unexpected first IPv6 %+v", e)
+	}
+	entries, _ = store.Query(Filter{Record: "example.org"})
+	if len(entries) != 1 || entries[0].Result != Failed || entries[0].Error != "API call failed" || entries[0].Action != report.Create {
+		t.Errorf("Unexpected entries for example.org %+v", entries)
+	}
+	entries, _ = store.Query(Filter{Record: "www.example.com."})
+	if len(entries) != 1 || entries[0].Old != "192.0.2.1" || entries[0].New != "192.0.2.2" || entries[0].Result != Succeeded {
+		t.Errorf("Unexpected entries for www.example.com %+v", entries)
+	}
+	entries, _ = store.Query(Filter{Since: time.Now().Add(time.Hour)})
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries in the future, got %+v", entries)
+	}
+}
+
+func TestJSONLStore(t *testing.T) {
+	testStore(t, Configuration{Path: filepath.Join(t.TempDir(), "history.jsonl")})
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, Configuration{Path: filepath.Join(t.TempDir(), "history.db")})
+}
+
+func TestConfiguration(t *testing.T) {
+	if b := (Configuration{Path: "history.bolt"}).backend(); b != BoltBackend {
+		t.Errorf("Expected bolt backend, got %s", b)
+	}
+	if b := (Configuration{Path: "history.db", Backend: JSONLBackend}).backend(); b != JSONLBackend {
+		t.Errorf("Expected jsonl backend, got %s", b)
+	}
+	if err := (Configuration{Path: "history", Backend: "sqlite"}).Validate(); err == nil {
+		t.Errorf("Unknown backend did not error")
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var b bytes.Buffer
+	WriteTable(&b, []Entry{{Kind: IPChange, Family: "ipv4", New: "192.0.2.2"}, {Kind: Mutation, Domain: "example.com", Name: "www", Action: report.Update}})
+	if !strings.Contains(b.String(), "www.example.com") || !strings.Contains(b.String(), "ipv4") {
+		t.Errorf("Unexpected table:\n%s", b.String())
+	}
+}
@@ -1,30 +1,38 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/sudneo/home-ddns/api"
 	"github.com/sudneo/home-ddns/config"
-	"github.com/sudneo/home-ddns/models"
-	"github.com/sudneo/home-ddns/utils"
+	"github.com/sudneo/home-ddns/engine"
+	"github.com/sudneo/home-ddns/history"
+	"github.com/sudneo/home-ddns/metrics"
+	"github.com/sudneo/home-ddns/netwatch"
+	"github.com/sudneo/home-ddns/notify"
+	"github.com/sudneo/home-ddns/report"
+	"github.com/sudneo/home-ddns/schedule"
+	"github.com/sudneo/home-ddns/status"
 )
 
+// Exit codes of one-shot executions
 const (
-	godaddyProvider = "Godaddy"
-	porkbunProvider = "Porkbun"
+	exitSuccess          = 0
+	exitConfigError      = 1
+	exitDiscoveryFailure = 2
+	exitPartialFailure   = 3
 )
 
-// Map to register providers
-// Each name (used in the config) is matched
-// with the corresponding handler type
-var providersMap = map[string]models.Provider{
-	godaddyProvider: &api.GodaddyHandler{},
-	porkbunProvider: &api.PorkbunHandler{},
-}
+// Environment variable holding the token required by the /trigger endpoint
+const triggerTokenEnv = "HOME_DDNS_TRIGGER_TOKEN"
 
 func init() {
 	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
@@ -32,125 +40,379 @@ func init() {
 	log.SetLevel(log.InfoLevel)
 }
 
-func processDomain(d config.DomainConfiguration, handler models.Provider, externalIP string) error {
-	for _, record := range d.Records {
-		dnsRecord, err := handler.GetRecord(d.Domain, record)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"Error":  err,
-				"Record": record.Name,
-			}).Error("Failed to process DNS record")
-			continue
-		}
-		// If the DNS record does not have a value specified, set sane defaults
-		if record.Value == "" {
-			if record.Type == "CNAME" {
-				record.Value = "@"
-			} else {
-				record.Value = externalIP
-			}
+// logConfigError logs a configuration error, one line for each problem found
+func logConfigError(err error) {
+	var invalid *config.InvalidConfiguration
+	if errors.As(err, &invalid) && len(invalid.Problems) > 0 {
+		log.Errorf("Invalid configuration, %s", invalid.Description)
+		for _, problem := range invalid.Problems {
+			log.Error(problem.String())
 		}
-		// If the current record does not exist, the DNS record must be created
-		if dnsRecord.Value == "" {
-			log.WithFields(log.Fields{
-				"Name": record.Name,
-			}).Debug("Not found existing record for domain, creating a new one")
-			err = handler.SetRecord(d.Domain, record)
-		} else {
-			// If the record does exist, but it's not up-to-date, update it
-			if dnsRecord.Value != record.Value {
-				log.WithFields(log.Fields{
-					"Name": record.Name,
-				}).Debug("Existing record found with old data, updating")
-				err = handler.UpdateRecord(d.Domain, record)
-			} else {
+		return
+	}
+	log.Error(err)
+}
+
+// writeReport prints the report of an execution in the requested format
+func writeReport(r *report.Report, format string) error {
+	switch format {
+	case "table":
+		return r.WriteTable(os.Stdout)
+	case "json":
+		return r.WriteJSON(os.Stdout)
+	}
+	return nil
+}
+
+// exitCode maps the status of an execution to the exit code of the process
+func exitCode(status report.Status) int {
+	switch status {
+	case report.DiscoveryFailure:
+		return exitDiscoveryFailure
+	case report.PartialFailure:
+		return exitPartialFailure
+	}
+	return exitSuccess
+}
+
+// logReport summarizes an execution in the logs, used in cron mode
+func logReport(r *report.Report) {
+	counts := r.Counts()
+	entry := log.WithFields(log.Fields{
+		"Created":   counts[report.Created],
+		"Updated":   counts[report.Updated],
+		"Unchanged": counts[report.Unchanged],
+		"Failed":    counts[report.Failed],
+		"Skipped":   counts[report.Skipped],
+	})
+	switch r.Status() {
+	case report.Success:
+		entry.Info("Execution completed successfully")
+	case report.DiscoveryFailure:
+		entry.WithField("Error", r.DiscoveryError).Error("Execution failed, public IP could not be discovered")
+	default:
+		entry.Warn("Execution completed with failures")
+	}
+}
+
+// serve runs the HTTP listener of cron mode until the context is cancelled
+func serve(ctx context.Context, addr string, tracker *status.Tracker, trigger *engine.Trigger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", status.HealthHandler())
+	mux.Handle("/readyz", tracker.ReadyHandler())
+	mux.Handle("/status", tracker.StatusHandler())
+	if token := os.Getenv(triggerTokenEnv); token != "" {
+		mux.Handle("/trigger", trigger.Handler(token))
+	} else {
+		log.WithFields(log.Fields{
+			"Variable": triggerTokenEnv,
+		}).Error("No trigger token set, the /trigger endpoint is disabled")
+	}
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	log.WithFields(log.Fields{
+		"Address": addr,
+	}).Info("Serving metrics and status")
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithFields(log.Fields{
+			"Error":   err,
+			"Address": addr,
+		}).Error("HTTP listener failed")
+	}
+}
+
+// watch triggers an execution of every record whenever the addresses of the interface change.
+// The regular interval keeps working as a safety net if watching fails
+func watch(ctx context.Context, iface string, debounce time.Duration, trigger *engine.Trigger) {
+	log.WithFields(log.Fields{
+		"Interface": iface,
+	}).Info("Watching network changes")
+	err := netwatch.Watch(ctx, iface, debounce, func() {
+		log.WithFields(log.Fields{
+			"Interface": iface,
+		}).Info("Execution triggered by a network change")
+		trigger.Fire(engine.Scope{})
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error":     err,
+			"Interface": iface,
+		}).Error("Failed to watch network changes, relying on the interval only")
+	}
+}
+
+// readDaemonConfig reads the configuration of cron mode, using the interval as default schedule
+func readDaemonConfig(configuration string, format config.Format, interval time.Duration) (config.Config, error) {
+	conf, err := config.ReadConfigFormat(configuration, format)
+	if err != nil {
+		return conf, err
+	}
+	if conf.Schedule.Cron == "" {
+		conf.Schedule.Cron = fmt.Sprintf("@every %s", interval)
+	}
+	return conf, nil
+}
+
+// scheduleEntries builds a scheduler entry for each provider account, domain and schedule,
+// together with the target selecting the records of each entry
+func scheduleEntries(conf config.Config) ([]schedule.Entry, map[string]engine.Target) {
+	var entries []schedule.Entry
+	targets := make(map[string]engine.Target)
+	for _, provider := range conf.Providers {
+		for _, domain := range provider.Domains {
+			sc := conf.DomainSchedule(provider, domain)
+			key := fmt.Sprintf("%s/%s/%s", provider.ID, domain.Domain, sc)
+			if _, ok := targets[key]; ok {
+				continue
+			}
+			parsed, err := sc.Parse()
+			if err != nil {
+				// Schedules are validated with the configuration, this should never happen
 				log.WithFields(log.Fields{
-					"Name":  record.Name,
-					"Value": record.Value,
-					"DNS":   dnsRecord.Value,
-				}).Debug("Correct record already exists, nothing to do")
+					"Error":  err,
+					"Domain": domain.Domain,
+				}).Error("Invalid schedule, domain will not be processed")
+				continue
 			}
+			entries = append(entries, schedule.Entry{Key: key, Schedule: parsed})
+			targets[key] = engine.Target{Provider: provider.ID, Domain: domain.Domain, Schedule: sc.String()}
 		}
 	}
-	return nil
+	return entries, targets
 }
 
-func run(c config.Config) error {
-	// This call is done here to minimize requests to third parties
-	externalIP, err := utils.GetPublicIP()
-	if err != nil {
-		return err
-	}
-	if len(externalIP) == 0 {
-		log.Error("No external IP obtained, likely failed to call ifconfig.io")
-		return errors.New("Failed to query external IP")
-	}
-	// Process providers one by one
-	for _, provider := range c.Providers {
-		// Match the provider name with the corresponding type using the global map
-		handler, ok := providersMap[provider.Name]
-		if ok {
-			handler.SetAPIID(provider.ClientID)
-			handler.SetAPIKey(provider.ClientKey)
+// waitForExecution blocks until the next execution is due, either because of the schedule
+// or because it was requested, and returns the records to process.
+// The boolean is false when the context is cancelled
+func waitForExecution(ctx context.Context, scheduler *schedule.Scheduler, targets map[string]engine.Target, trigger *engine.Trigger, usr1 <-chan os.Signal) (engine.Scope, bool) {
+	for {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if next := scheduler.Next(); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			timeout = timer.C
+		}
+		scope, ok, done := waitOnce(ctx, timeout, scheduler, targets, trigger, usr1)
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return scope, ok
+		}
+	}
+}
+
+// waitOnce handles a single event for waitForExecution. done is false
+// when the event did not result in any record to process
+func waitOnce(ctx context.Context, timeout <-chan time.Time, scheduler *schedule.Scheduler, targets map[string]engine.Target, trigger *engine.Trigger, usr1 <-chan os.Signal) (scope engine.Scope, ok bool, done bool) {
+	select {
+	case <-ctx.Done():
+		return engine.Scope{}, false, true
+	case <-timeout:
+		for _, key := range scheduler.Due(time.Now()) {
+			scope.Targets = append(scope.Targets, targets[key])
+		}
+		return scope, true, !scope.All()
+	case <-usr1:
+		log.Info("Execution triggered through SIGUSR1")
+		// A full execution covers any pending request
+		trigger.Take()
+		return engine.Scope{}, true, true
+	case <-trigger.C():
+		scope, ok = trigger.Take()
+		return scope, ok, ok
+	}
+}
+
+// reload keeps the configuration up-to-date, reloading it when its files change or on SIGHUP.
+// Every change triggers an execution of all the records
+func reload(ctx context.Context, watcher *config.Watcher, trigger *engine.Trigger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		if err := watcher.Watch(ctx); err != nil {
 			log.WithFields(log.Fields{
-				"Domains":  len(provider.Domains),
-				"Provider": provider.Name,
-			}).Debug("Processing domains for provider")
-			for _, domain := range provider.Domains {
-				err := processDomain(domain, handler, externalIP)
-				if err != nil {
-					log.Error(err)
-				}
-			}
+				"Error": err,
+			}).Error("Failed to watch the configuration files, reload with SIGHUP")
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("Configuration reload requested through SIGHUP")
+			watcher.Reload()
+		case <-watcher.Changed():
+			trigger.Fire(engine.Scope{})
+		}
+	}
+}
 
-		} else {
+// configureNotifications applies the notifications of a configuration to the dispatcher
+func configureNotifications(dispatcher *notify.Dispatcher, conf config.Config) {
+	// Notifications are validated with the configuration, this should never fail
+	if err := dispatcher.Configure(conf.Notifications); err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("Invalid notifications, keeping the previous ones")
+	}
+}
+
+// historyRecorder appends executions to the history store of the current configuration
+type historyRecorder struct {
+	conf     history.Configuration
+	recorder *history.Recorder
+}
+
+// observe records an execution, opening the store again when its configuration changed
+func (h *historyRecorder) observe(conf history.Configuration, r *report.Report) {
+	if !conf.Enabled() {
+		return
+	}
+	if h.recorder == nil || conf != h.conf {
+		store, err := history.Open(conf)
+		if err != nil {
 			log.WithFields(log.Fields{
-				"Provider": provider.Name,
-			}).Error("Provider not recognized")
-			continue
+				"Error": err,
+			}).Error("Failed to open the history")
+			return
 		}
+		h.conf = conf
+		h.recorder = history.NewRecorder(store)
+	}
+	if err := h.recorder.Observe(r); err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+			"File":  conf.Path,
+		}).Error("Failed to record the execution in the history")
+	}
+}
+
+// daemon executes the configured records according to their schedule, or right away
+// when the trigger fires, until the context is cancelled
+func daemon(ctx context.Context, watcher *config.Watcher, tracker *status.Tracker, trigger *engine.Trigger, dispatcher *notify.Dispatcher) {
+	// SIGUSR1 requests an immediate execution of every record
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	go reload(ctx, watcher, trigger)
+	conf := watcher.Config()
+	configureNotifications(dispatcher, conf)
+	entries, targets := scheduleEntries(conf)
+	scheduler := schedule.NewScheduler(entries, time.Now())
+	recorder := &historyRecorder{}
+	// Every record is processed once at startup
+	scope := engine.Scope{}
+	for {
+		r := engine.Run(ctx, conf, scope)
+		logReport(r)
+		tracker.Observe(r)
+		tracker.SetNextRun(scheduler.Next())
+		dispatcher.Observe(ctx, r)
+		recorder.observe(conf.History, r)
+		var ok bool
+		scope, ok = waitForExecution(ctx, scheduler, targets, trigger, usr1)
+		if !ok {
+			log.Info("Termination requested, exiting")
+			return
+		}
+		conf = watcher.Config()
+		configureNotifications(dispatcher, conf)
+		entries, targets = scheduleEntries(conf)
+		scheduler.Update(entries, time.Now())
 	}
-	return nil
 }
 
 func main() {
-	var configuration = flag.String("config", "config.yaml", "Configuration file to use")
-	var debug = flag.Bool("v", false, "Enable debug logs")
-	var json = flag.Bool("j", false, "Enable logging in JSON")
-	var cronMode = flag.Bool("cron", false, "Enable cron mode (execute every interval)")
-	var cronInterval = flag.Int("interval", 60, "Interval in minutes between each execution (requires cron mode)")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			os.Exit(historyCommand(os.Args[2:]))
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		}
+	}
+	// Invalid flags exit like an invalid configuration, rather than with 2 as the flag package does
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	var configuration = fs.String("config", "config.yaml", "Configuration file, or directory of configuration files, to use")
+	var configFormat = fs.String("format", "", "Format of the configuration: yaml, json or toml (default from the file extension)")
+	var debug = fs.Bool("v", false, "Enable debug logs")
+	var json = fs.Bool("j", false, "Enable logging in JSON")
+	var cronMode = fs.Bool("cron", false, "Enable cron mode (execute every interval)")
+	var cronInterval = fs.Int("interval", 60, "Interval in minutes between each execution when no schedule is configured (requires cron mode)")
+	var reportFormat = fs.String("report", "table", "Format of the report printed at the end of a one-shot execution: table, json or none")
+	var listen = fs.String("listen", "", "Address of the HTTP listener exposing metrics and status endpoints, e.g. :9100 (requires cron mode)")
+	var readyFailures = fs.Int("ready-failures", 3, "Number of consecutive failed executions after which /readyz fails")
+	var watchInterface = fs.String("watch-interface", "", "WAN interface whose address changes trigger an immediate execution, Linux only (requires cron mode)")
+	var watchDebounce = fs.Duration("watch-debounce", 5*time.Second, "Delay without further network changes before the triggered execution starts")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(flagExitCode(err))
+	}
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 	}
 	if *json {
 		log.SetFormatter(&log.JSONFormatter{})
 	}
+	// SIGINT and SIGTERM cancel in-flight requests and stop the execution
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	format, err := config.ParseFormat(*configFormat)
+	if err != nil {
+		log.Error(err)
+		os.Exit(exitConfigError)
+	}
 	if !*cronMode {
-		conf, err := config.ReadConfig(*configuration)
-		if err != nil {
-			log.Fatal(err)
-			return
+		if *reportFormat != "table" && *reportFormat != "json" && *reportFormat != "none" {
+			log.Errorf("Unknown report format %s", *reportFormat)
+			os.Exit(exitConfigError)
+		}
+		// Keep stdout parseable when the report is printed as JSON
+		if *reportFormat == "json" {
+			log.SetOutput(os.Stderr)
 		}
-		err = run(conf)
+		conf, err := config.ReadConfigFormat(*configuration, format)
 		if err != nil {
+			logConfigError(err)
+			os.Exit(exitConfigError)
+		}
+		dispatcher := notify.NewDispatcher()
+		configureNotifications(dispatcher, conf)
+		r := engine.Run(ctx, conf, engine.Scope{})
+		// A single execution cannot detect IP changes, and only notifies failures with failure_threshold 1
+		dispatcher.Observe(ctx, r)
+		(&historyRecorder{}).observe(conf.History, r)
+		if err := writeReport(r, *reportFormat); err != nil {
 			log.Error(err)
 		}
-		log.Info("Processing completed successfully")
+		stop()
+		os.Exit(exitCode(r.Status()))
 	} else {
-		for {
-			conf, err := config.ReadConfig(*configuration)
-			if err != nil {
-				log.Fatal(err)
-				return
-			}
-			log.Debug("Configuration reloaded")
-			err = run(conf)
-			if err != nil {
-				log.Error(err)
-			}
-			log.Debug("Execution completed successfully")
-			time.Sleep(time.Duration(*cronInterval) * time.Minute)
+		interval := time.Duration(*cronInterval) * time.Minute
+		watcher, err := config.NewWatcher(*configuration, func(configFile string) (config.Config, error) {
+			return readDaemonConfig(configFile, format, interval)
+		})
+		if err != nil {
+			logConfigError(err)
+			os.Exit(exitConfigError)
+		}
+		tracker := status.NewTracker(*readyFailures)
+		trigger := engine.NewTrigger()
+		if *listen != "" {
+			go serve(ctx, *listen, tracker, trigger)
+		}
+		if *watchInterface != "" {
+			go watch(ctx, *watchInterface, *watchDebounce, trigger)
 		}
+		daemon(ctx, watcher, tracker, trigger, notify.NewDispatcher())
 	}
 }
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/models"
+	"github.com/sudneo/home-ddns/report"
+	"github.com/sudneo/home-ddns/utils"
+)
+
+// Default registry where all the metrics of the tool are registered
+var Default = NewRegistry()
+
+var (
+	runsTotal = Default.NewCounterVec("home_ddns_runs_total",
+		"Number of executions by status.", "status")
+	lastRunTimestamp = Default.NewGaugeVec("home_ddns_last_run_timestamp_seconds",
+		"Unix time of the end of the last execution.")
+	lastSuccessTimestamp = Default.NewGaugeVec("home_ddns_last_success_timestamp_seconds",
+		"Unix time of the end of the last execution where every record was processed successfully.")
+	ipInfo = Default.NewGaugeVec("home_ddns_ip_info",
+		"Currently discovered public IP address, the value is always 1.", "family", "address")
+	ipChangesTotal = Default.NewCounterVec("home_ddns_ip_changes_total",
+		"Number of times the discovered public IP address changed.", "family")
+	recordsTotal = Default.NewCounterVec("home_ddns_records_total",
+		"Number of processed records by outcome.", "account", "domain", "outcome")
+	apiRequestsTotal = Default.NewCounterVec("home_ddns_api_requests_total",
+		"Number of calls made to provider APIs by status.", "provider", "account", "method", "status")
+	apiRequestDuration = Default.NewHistogramVec("home_ddns_api_request_duration_seconds",
+		"Latency of the calls made to provider APIs, each retry is measured separately.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "provider", "account", "method", "status")
+	propagationTotal = Default.NewCounterVec("home_ddns_propagation_checks_total",
+		"Number of changes verified against the authoritative nameservers by status.", "account", "domain", "status")
+	propagationDuration = Default.NewHistogramVec("home_ddns_propagation_duration_seconds",
+		"Time until a change was served by every authoritative nameserver, or verification gave up.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600}, "account", "domain", "status")
+)
+
+// Last observed address for each IP family, used to count changes
+var (
+	addressesMu sync.Mutex
+	addresses   = make(map[string]string)
+)
+
+// Handler serves the default registry over HTTP
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// ObserveIP records the currently discovered public IP address, counting changes
+func ObserveIP(address string) {
+	family := utils.IPFamily(address)
+	addressesMu.Lock()
+	previous, known := addresses[family]
+	addresses[family] = address
+	addressesMu.Unlock()
+	if known && previous == address {
+		return
+	}
+	if known {
+		ipChangesTotal.Inc(family)
+	}
+	ipInfo.DeleteMatching("family", family)
+	ipInfo.Set(1, family, address)
+}
+
+// ObserveReport updates the metrics with the outcome of an execution
+func ObserveReport(r *report.Report) {
+	status := r.Status()
+	runsTotal.Inc(string(status))
+	lastRunTimestamp.Set(float64(r.Finished.Unix()))
+	if status == report.Success {
+		lastSuccessTimestamp.Set(float64(r.Finished.Unix()))
+	}
+	for _, address := range []string{r.ExternalIP, r.ExternalIPv6} {
+		if address != "" {
+			ObserveIP(address)
+		}
+	}
+	for _, result := range r.Results {
+		recordsTotal.Inc(result.Account, result.Domain, string(result.Outcome))
+		if p := result.Propagation; p != nil {
+			propagationTotal.Inc(result.Account, result.Domain, string(p.Status))
+			propagationDuration.Observe(p.ElapsedSeconds, result.Account, result.Domain, string(p.Status))
+		}
+	}
+}
+
+// callStatus returns the label describing the result of an API call
+func callStatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var apiErr *api.ErrAPIFailed
+	if errors.As(err, &apiErr) {
+		return strconv.Itoa(apiErr.StatusCode)
+	}
+	return "error"
+}
+
+// instrumentedProvider wraps a provider handler measuring every call
+type instrumentedProvider struct {
+	provider     models.Provider
+	providerName string
+	account      string
+}
+
+// InstrumentProvider wraps a provider handler so that its calls are counted and timed
+func InstrumentProvider(provider models.Provider, providerName string, account string) models.Provider {
+	return &instrumentedProvider{provider: provider, providerName: providerName, account: account}
+}
+
+func (i *instrumentedProvider) observe(method string, start time.Time, err error) {
+	status := callStatus(err)
+	apiRequestsTotal.Inc(i.providerName, i.account, method, status)
+	apiRequestDuration.Observe(time.Since(start).Seconds(), i.providerName, i.account, method, status)
+}
+
+func (i *instrumentedProvider) GetRecord(ctx context.Context, domain string, record models.DNSRecord) (models.DNSRecord, error) {
+	start := time.Now()
+	dnsRecord, err := i.provider.GetRecord(ctx, domain, record)
+	i.observe("get", start, err)
+	return dnsRecord, err
+}
+
+func (i *instrumentedProvider) SetRecord(ctx context.Context, domain string, record models.DNSRecord) error {
+	start := time.Now()
+	err := i.provider.SetRecord(ctx, domain, record)
+	i.observe("set", start, err)
+	return err
+}
+
+func (i *instrumentedProvider) UpdateRecord(ctx context.Context, domain string, record models.DNSRecord) error {
+	start := time.Now()
+	err := i.provider.UpdateRecord(ctx, domain, record)
+	i.observe("update", start, err)
+	return err
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sudneo/home-ddns/api"
+	"github.com/sudneo/home-ddns/models"
+	"github.com/sudneo/home-ddns/report"
+)
+
+type fakeProvider struct {
+	err error
+}
+
+func (f *fakeProvider) GetRecord(ctx context.Context, domain string, record models.DNSRecord) (models.DNSRecord, error) {
+	return models.DNSRecord{}, f.err
+}
+
+func (f *fakeProvider) SetRecord(ctx context.Context, domain string, record models.DNSRecord) error {
+	return f.err
+}
+
+func (f *fakeProvider) UpdateRecord(ctx context.Context, domain string, record models.DNSRecord) error {
+	return f.err
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("test_total", "A counter.", "label")
+	gauge := r.NewGaugeVec("test_gauge", "A gauge.")
+	histogram := r.NewHistogramVec("test_seconds", "A histogram.", []float64{1, 0.1}, "label")
+	counter.Inc(`quote"d`)
+	counter.Add(2, `quote"d`)
+	gauge.Set(1.5)
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	var b strings.Builder
+	r.WriteTo(&b)
+	expected := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{label="quote\"d"} 3
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{label="a",le="0.1"} 1
+test_seconds_bucket{label="a",le="1"} 2
+test_seconds_bucket{label="a",le="+Inf"} 2
+test_seconds_sum{label="a"} 0.55
+test_seconds_count{label="a"} 2
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected exposition, got:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ok := InstrumentProvider(&fakeProvider{}, "Godaddy", "personal")
+	failing := InstrumentProvider(&fakeProvider{err: &api.ErrAPIFailed{StatusCode: 503}}, "Godaddy", "personal")
+	ok.GetRecord(context.Background(), "example.com", models.DNSRecord{})
+	failing.UpdateRecord(context.Background(), "example.com", models.DNSRecord{})
+	r := report.New()
+	r.ExternalIP = "192.0.2.1"
+	r.Add(report.RecordResult{Account: "personal", Domain: "example.com", Outcome: report.Updated, Propagation: &report.Propagation{Status: report.Verified, ElapsedSeconds: 12}})
+	r.Finish()
+	ObserveReport(r)
+	r.ExternalIP = "192.0.2.2"
+	r.ExternalIPv6 = "2001:db8::1"
+	ObserveReport(r)
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Scraping metrics failed: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		`home_ddns_api_requests_total{provider="Godaddy",account="personal",method="get",status="ok"} 1`,
+		`home_ddns_api_requests_total{provider="Godaddy",account="personal",method="update",status="503"} 1`,
+		`home_ddns_api_request_duration_seconds_count{provider="Godaddy",account="personal",method="get",status="ok"} 1`,
+		`home_ddns_records_total{account="personal",domain="example.com",outcome="updated"} 2`,
+		`home_ddns_ip_info{family="ipv4",address="192.0.2.2"} 1`,
+		`home_ddns_ip_info{family="ipv6",address="2001:db8::1"} 1`,
+		`home_ddns_ip_changes_total{family="ipv4"} 1`,
+		`home_ddns_runs_total{status="success"} 2`,
+		`home_ddns_propagation_checks_total{account="personal",domain="example.com",status="verified"} 2`,
+		`home_ddns_propagation_duration_seconds_bucket{account="personal",domain="example.com",status="verified",le="30"} 2`,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Metrics do not contain %s:\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), `address="192.0.2.1"`) {
+		t.Errorf("Previous IP address still exported")
+	}
+}
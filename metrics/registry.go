@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal implementation of the Prometheus text exposition format,
+// enough for the handful of metrics exported by the tool
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// Registry holds a set of metric families and renders them in Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    metricType
+	labels  []string
+	buckets []float64
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+	// Only used by histograms
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name string, help string, kind metricType, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, samples: make(map[string]*sample)}
+	r.families = append(r.families, f)
+	return f
+}
+
+// get returns the sample for the given label values, creating it when missing. Must be called with the lock held
+func (f *family) get(labelValues []string) *sample {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == histogramType {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.samples[key] = s
+	}
+	return s
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels
+type CounterVec struct {
+	registry *Registry
+	family   *family
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{registry: r, family: r.register(name, help, counterType, labels, nil)}
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.family.name))
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.family.get(labelValues).value += value
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// GaugeVec is a value which can go up and down, partitioned by labels
+type GaugeVec struct {
+	registry *Registry
+	family   *family
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{registry: r, family: r.register(name, help, gaugeType, labels, nil)}
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+	g.family.get(labelValues).value = value
+}
+
+// DeleteMatching removes the samples whose label at the given position has the given value
+func (g *GaugeVec) DeleteMatching(label string, value string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+	for i, name := range g.family.labels {
+		if name != label {
+			continue
+		}
+		for key, s := range g.family.samples {
+			if s.labelValues[i] == value {
+				delete(g.family.samples, key)
+			}
+		}
+	}
+}
+
+// HistogramVec counts observations in configurable buckets, partitioned by labels
+type HistogramVec struct {
+	registry *Registry
+	family   *family
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{registry: r, family: r.register(name, help, histogramType, labels, sorted)}
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+	s := h.family.get(labelValues)
+	for i, bound := range h.family.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// WriteTo renders all the metrics in Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b strings.Builder
+	for _, f := range r.families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+		keys := make([]string, 0, len(f.samples))
+		for key := range f.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.samples[key]
+			if f.kind != histogramType {
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+				continue
+			}
+			for i, bound := range f.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatValue(bound)), s.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), s.count)
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handler serves the metrics of the registry over HTTP
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
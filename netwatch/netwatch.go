@@ -0,0 +1,34 @@
+package netwatch
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnsupported is returned by Watch on platforms without rtnetlink
+var ErrUnsupported = errors.New("Watching network changes is only supported on Linux")
+
+// debouncer calls notify once no event was received for the given delay
+type debouncer struct {
+	delay  time.Duration
+	notify func()
+	timer  *time.Timer
+}
+
+func newDebouncer(delay time.Duration, notify func()) *debouncer {
+	return &debouncer{delay: delay, notify: notify}
+}
+
+// event records a change, postponing the notification
+func (d *debouncer) event() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(d.delay, d.notify)
+}
+
+func (d *debouncer) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
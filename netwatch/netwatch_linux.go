@@ -0,0 +1,121 @@
+package netwatch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Interval at which the blocking socket read is interrupted to check for cancellation
+const readTimeout = time.Second
+
+// Watch subscribes to rtnetlink address and route events and calls notify, debounced,
+// whenever the addresses or the default route of the given interface change.
+// It blocks until the context is cancelled
+func Watch(ctx context.Context, iface string, debounce time.Duration, notify func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("Failed to open netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	groups := uint32(unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR | unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE)
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups})
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to netlink events: %w", err)
+	}
+	tv := syscall.NsecToTimeval(readTimeout.Nanoseconds())
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return fmt.Errorf("Failed to configure netlink socket: %w", err)
+	}
+	d := newDebouncer(debounce, notify)
+	defer d.stop()
+	buf := make([]byte, 1<<16)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			// The kernel drops messages when the buffer is full, a change may have been missed
+			if errors.Is(err, syscall.ENOBUFS) {
+				d.event()
+				continue
+			}
+			return fmt.Errorf("Failed to read netlink events: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Debug("Ignoring malformed netlink message")
+			continue
+		}
+		// The index is looked up every time, the interface may be created after startup (e.g. PPP)
+		link, err := net.InterfaceByName(iface)
+		if err != nil {
+			continue
+		}
+		if relevant(msgs, link.Index) {
+			log.WithFields(log.Fields{
+				"Interface": iface,
+			}).Debug("Network change detected")
+			d.event()
+		}
+	}
+}
+
+// relevant reports whether any of the messages is about an address of the interface
+// or a default route going through it
+func relevant(msgs []syscall.NetlinkMessage, index int) bool {
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+			if len(m.Data) < syscall.SizeofIfAddrmsg {
+				continue
+			}
+			msg := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+			if int(msg.Index) == index {
+				return true
+			}
+		case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+			if len(m.Data) < syscall.SizeofRtMsg {
+				continue
+			}
+			msg := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+			if msg.Dst_len != 0 {
+				continue
+			}
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				continue
+			}
+			for _, attr := range attrs {
+				if attr.Attr.Type == syscall.RTA_OIF && len(attr.Value) >= 4 && int(nativeEndian.Uint32(attr.Value)) == index {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Netlink messages use the byte order of the host
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
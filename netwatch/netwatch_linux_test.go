@@ -0,0 +1,56 @@
+package netwatch
+
+import (
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func addrMessage(kind uint16, index uint32) syscall.NetlinkMessage {
+	msg := syscall.IfAddrmsg{Family: syscall.AF_INET, Index: index}
+	data := (*[syscall.SizeofIfAddrmsg]byte)(unsafe.Pointer(&msg))[:]
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: kind}, Data: append([]byte(nil), data...)}
+}
+
+func routeMessage(dstLen uint8, oif uint32) syscall.NetlinkMessage {
+	msg := syscall.RtMsg{Family: syscall.AF_INET, Dst_len: dstLen}
+	data := append([]byte(nil), (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(&msg))[:]...)
+	attr := make([]byte, 8)
+	nativeEndian.PutUint16(attr[0:2], 8)
+	nativeEndian.PutUint16(attr[2:4], syscall.RTA_OIF)
+	nativeEndian.PutUint32(attr[4:8], oif)
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: append(data, attr...)}
+}
+
+func TestRelevant(t *testing.T) {
+	cases := []struct {
+		name     string
+		msg      syscall.NetlinkMessage
+		relevant bool
+	}{
+		{"new address on interface", addrMessage(syscall.RTM_NEWADDR, 2), true},
+		{"deleted address on interface", addrMessage(syscall.RTM_DELADDR, 2), true},
+		{"address on other interface", addrMessage(syscall.RTM_NEWADDR, 3), false},
+		{"default route through interface", routeMessage(0, 2), true},
+		{"default route through other interface", routeMessage(0, 3), false},
+		{"subnet route through interface", routeMessage(24, 2), false},
+	}
+	for _, c := range cases {
+		if relevant([]syscall.NetlinkMessage{c.msg}, 2) != c.relevant {
+			t.Errorf("%s: expected relevant %t", c.name, c.relevant)
+		}
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	notified := make(chan struct{}, 10)
+	d := newDebouncer(20*time.Millisecond, func() { notified <- struct{}{} })
+	for i := 0; i < 5; i++ {
+		d.event()
+	}
+	time.Sleep(60 * time.Millisecond)
+	if len(notified) != 1 {
+		t.Errorf("Expected a single notification for a burst of events, got %d", len(notified))
+	}
+}
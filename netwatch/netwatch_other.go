@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package netwatch
+
+import (
+	"context"
+	"time"
+)
+
+// Watch is not supported outside of Linux
+func Watch(ctx context.Context, iface string, debounce time.Duration, notify func()) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTPConfiguration of email notifications
+type SMTPConfiguration struct {
+	Host string `yaml:"host"`
+	// Default 587, or 465 with implicit TLS
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Use implicit TLS instead of STARTTLS
+	TLS bool `yaml:"tls"`
+}
+
+func (c SMTPConfiguration) validate() error {
+	switch {
+	case c.Host == "":
+		return errors.New("smtp.host is required for email notifications")
+	case c.From == "":
+		return errors.New("smtp.from is required for email notifications")
+	case len(c.To) == 0:
+		return errors.New("smtp.to is required for email notifications")
+	case c.Port < 0 || c.Port > 65535:
+		return fmt.Errorf("invalid smtp.port %d", c.Port)
+	}
+	return nil
+}
+
+// email sends events through an SMTP relay, using STARTTLS when the server supports it
+type email struct {
+	smtp     SMTPConfiguration
+	template *template.Template
+}
+
+func newEmail(c Configuration, tmpl *template.Template) *email {
+	settings := c.SMTP
+	if settings.Port == 0 {
+		settings.Port = 587
+		if settings.TLS {
+			settings.Port = 465
+		}
+	}
+	return &email{smtp: settings, template: tmpl}
+}
+
+func (m *email) Notify(ctx context.Context, e Event) error {
+	text, err := render(m.template, e)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.smtp.Host, strconv.Itoa(m.smtp.Port))
+	var conn net.Conn
+	dialer := &net.Dialer{}
+	if m.smtp.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.smtp.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, m.smtp.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !m.smtp.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: m.smtp.Host}); err != nil {
+			return err
+		}
+	}
+	if m.smtp.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.smtp.Username, m.smtp.Password, m.smtp.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.smtp.From); err != nil {
+		return err
+	}
+	for _, to := range m.smtp.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	message := strings.Join([]string{
+		"From: " + m.smtp.From,
+		"To: " + strings.Join(m.smtp.To, ", "),
+		"Subject: home-ddns: " + e.Title,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		// Bare line feeds are not allowed by SMTP
+		strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n"),
+	}, "\r\n")
+	if _, err := w.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
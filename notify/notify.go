@@ -0,0 +1,320 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sudneo/home-ddns/report"
+	"github.com/sudneo/home-ddns/utils"
+)
+
+// EventKind identifies what happened
+type EventKind string
+
+const (
+	// The discovered public IP changed
+	IPChanged EventKind = "ip_changed"
+	// A record was created or updated
+	RecordUpdated EventKind = "record_updated"
+	// The configured number of consecutive executions failed
+	Failure EventKind = "failure"
+	// An execution succeeded after a failure was notified
+	Recovered EventKind = "recovered"
+)
+
+var allKinds = []EventKind{IPChanged, RecordUpdated, Failure, Recovered}
+
+const (
+	defaultFailureThreshold = 3
+	// Maximum duration of the delivery of a notification
+	notifyTimeout = 30 * time.Second
+)
+
+// Event describes something worth notifying
+type Event struct {
+	Kind EventKind `json:"kind"`
+	Time time.Time `json:"time"`
+	// Short human readable summary, used as title
+	Title string `json:"title"`
+	// Human readable description of the event
+	Message string `json:"message"`
+	// Set for IPChanged events
+	Family string `json:"family,omitempty"`
+	OldIP  string `json:"old_ip,omitempty"`
+	NewIP  string `json:"new_ip,omitempty"`
+	// Set for RecordUpdated events
+	Record *report.RecordResult `json:"record,omitempty"`
+	// Set for Failure and Recovered events
+	Failures int    `json:"failures,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Notifier delivers events to a destination
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Configuration of a single notification destination
+type Configuration struct {
+	// One of webhook, slack, matrix, ntfy, gotify or email
+	Type string `yaml:"type"`
+	// Events which are notified, all of them when empty
+	On []EventKind `yaml:"on"`
+	// Number of consecutive failed executions which trigger a failure notification (default 3)
+	FailureThreshold int `yaml:"failure_threshold"`
+	// Destination of webhook, slack, matrix, ntfy and gotify notifications
+	URL string `yaml:"url"`
+	// HTTP method of webhook notifications (default POST)
+	Method string `yaml:"method"`
+	// Additional HTTP headers of webhook notifications
+	Headers map[string]string `yaml:"headers"`
+	// Go template rendered with the event: the body of webhook notifications
+	// (default the event as JSON), the message text for the other types
+	Template string `yaml:"template"`
+	// Bearer token for ntfy, application token for gotify
+	Token string `yaml:"token"`
+	// Priority of ntfy and gotify notifications
+	Priority int `yaml:"priority"`
+	// SMTP settings of email notifications
+	SMTP SMTPConfiguration `yaml:"smtp"`
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. to embed a string in a JSON body
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func (c Configuration) parseTemplate() (*template.Template, error) {
+	if c.Template == "" {
+		return nil, nil
+	}
+	return template.New(c.Type).Funcs(templateFuncs).Option("missingkey=error").Parse(c.Template)
+}
+
+// Validate checks the configuration, without contacting the destination
+func (c Configuration) Validate() error {
+	for _, kind := range c.On {
+		valid := false
+		for _, k := range allKinds {
+			valid = valid || kind == k
+		}
+		if !valid {
+			return fmt.Errorf("unknown event %s", kind)
+		}
+	}
+	if c.FailureThreshold < 0 {
+		return errors.New("failure_threshold must not be negative")
+	}
+	if _, err := c.parseTemplate(); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	switch c.Type {
+	case "webhook", "slack", "matrix", "ntfy", "gotify":
+		if c.URL == "" {
+			return fmt.Errorf("url is required for %s notifications", c.Type)
+		}
+	case "email":
+		return c.SMTP.validate()
+	default:
+		return fmt.Errorf("unknown notification type %q", c.Type)
+	}
+	return nil
+}
+
+// New builds the notifier described by the configuration
+func New(c Configuration) (Notifier, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	tmpl, _ := c.parseTemplate()
+	switch c.Type {
+	case "webhook":
+		return newWebhook(c, tmpl), nil
+	case "slack", "matrix":
+		return newChat(c, tmpl), nil
+	case "ntfy":
+		return newNtfy(c, tmpl), nil
+	case "gotify":
+		return newGotify(c, tmpl), nil
+	}
+	return newEmail(c, tmpl), nil
+}
+
+// render returns the message of an event, using the template when configured
+func render(tmpl *template.Template, e Event) (string, error) {
+	if tmpl == nil {
+		return e.Message, nil
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, e); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+type route struct {
+	notifier  Notifier
+	on        map[EventKind]bool
+	threshold int
+	kind      string
+}
+
+// Dispatcher turns execution reports into events and delivers them to the configured notifiers
+type Dispatcher struct {
+	mu     sync.Mutex
+	routes []route
+	// Last discovered address for each IP family
+	ips map[string]string
+	// Number of consecutive failed executions
+	failures  int
+	lastError string
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{ips: make(map[string]string)}
+}
+
+// Configure replaces the notifiers, keeping the state used to detect changes and failures
+func (d *Dispatcher) Configure(configurations []Configuration) error {
+	routes := make([]route, 0, len(configurations))
+	for i, c := range configurations {
+		notifier, err := New(c)
+		if err != nil {
+			return fmt.Errorf("notification %d: %w", i, err)
+		}
+		r := route{notifier: notifier, on: make(map[EventKind]bool), threshold: c.FailureThreshold, kind: c.Type}
+		if r.threshold == 0 {
+			r.threshold = defaultFailureThreshold
+		}
+		kinds := c.On
+		if len(kinds) == 0 {
+			kinds = allKinds
+		}
+		for _, kind := range kinds {
+			r.on[kind] = true
+		}
+		routes = append(routes, r)
+	}
+	d.mu.Lock()
+	d.routes = routes
+	d.mu.Unlock()
+	return nil
+}
+
+// runError summarizes why an execution failed
+func runError(r *report.Report) string {
+	if r.DiscoveryError != "" {
+		return r.DiscoveryError
+	}
+	var errs []string
+	for _, result := range r.Results {
+		if result.Error != "" {
+			errs = append(errs, fmt.Sprintf("%s.%s: %s", result.Name, result.Domain, result.Error))
+		}
+	}
+	return strings.Join(errs, "; ")
+}
+
+// Observe generates the events of an execution and delivers them to the interested notifiers.
+// Delivery errors are logged and do not affect the other notifiers
+func (d *Dispatcher) Observe(ctx context.Context, r *report.Report) {
+	d.mu.Lock()
+	routes := d.routes
+	now := r.Finished
+	var events []Event
+	for _, address := range []string{r.ExternalIP, r.ExternalIPv6} {
+		if address == "" {
+			continue
+		}
+		family := utils.IPFamily(address)
+		previous, known := d.ips[family]
+		d.ips[family] = address
+		if known && previous != address {
+			events = append(events, Event{
+				Kind:    IPChanged,
+				Time:    now,
+				Title:   "Public IP changed",
+				Message: fmt.Sprintf("Public %s address changed from %s to %s", family, previous, address),
+				Family:  family,
+				OldIP:   previous,
+				NewIP:   address,
+			})
+		}
+	}
+	for i := range r.Results {
+		result := r.Results[i]
+		if result.Outcome != report.Created && result.Outcome != report.Updated {
+			continue
+		}
+		message := fmt.Sprintf("Record %s.%s (%s) %s with value %s", result.Name, result.Domain, result.Type, result.Outcome, result.Desired)
+		if result.Observed != "" {
+			message = fmt.Sprintf("%s, was %s", message, result.Observed)
+		}
+		events = append(events, Event{
+			Kind:    RecordUpdated,
+			Time:    now,
+			Title:   "DNS record " + string(result.Outcome),
+			Message: message,
+			Record:  &result,
+		})
+	}
+	previousFailures := d.failures
+	if r.Status() == report.Success {
+		d.failures = 0
+	} else {
+		d.failures++
+		d.lastError = runError(r)
+	}
+	failures := d.failures
+	lastError := d.lastError
+	d.mu.Unlock()
+
+	for _, rt := range routes {
+		routeEvents := events
+		if failures == rt.threshold {
+			routeEvents = append(routeEvents, Event{
+				Kind:     Failure,
+				Time:     now,
+				Title:    "Updates failing",
+				Message:  fmt.Sprintf("%d consecutive executions failed, last error: %s", failures, lastError),
+				Failures: failures,
+				Error:    lastError,
+			})
+		}
+		if failures == 0 && previousFailures >= rt.threshold {
+			routeEvents = append(routeEvents, Event{
+				Kind:     Recovered,
+				Time:     now,
+				Title:    "Updates recovered",
+				Message:  fmt.Sprintf("Execution succeeded after %d consecutive failures", previousFailures),
+				Failures: previousFailures,
+			})
+		}
+		for _, e := range routeEvents {
+			if !rt.on[e.Kind] {
+				continue
+			}
+			notifyCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+			err := rt.notifier.Notify(notifyCtx, e)
+			cancel()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+					"Type":  rt.kind,
+					"Event": e.Kind,
+				}).Error("Failed to deliver notification")
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sudneo/home-ddns/report"
+)
+
+// recorder stands in for the destination of a notifier, collecting every event
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Notify(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) kinds() []EventKind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []EventKind
+	for _, e := range r.events {
+		kinds = append(kinds, e.Kind)
+	}
+	r.events = nil
+	return kinds
+}
+
+func newReport(ip string, outcome report.Outcome) *report.Report {
+	r := report.New()
+	r.ExternalIP = ip
+	result := report.RecordResult{Account: "Godaddy", Domain: "example.com", Name: "home", Type: "A", Outcome: outcome, Desired: ip, Observed: "192.0.2.1"}
+	if outcome == report.Failed {
+		result.Error = "API call failed"
+	}
+	r.Add(result)
+	r.Finish()
+	return r
+}
+
+func equalKinds(a, b []EventKind) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDispatcher(t *testing.T) {
+	all := &recorder{}
+	failures := &recorder{}
+	d := NewDispatcher()
+	d.routes = []route{
+		{notifier: all, on: map[EventKind]bool{IPChanged: true, RecordUpdated: true, Failure: true, Recovered: true}, threshold: 2},
+		{notifier: failures, on: map[EventKind]bool{Failure: true, Recovered: true}, threshold: 3},
+	}
+	ctx := context.Background()
+	steps := []struct {
+		report   *report.Report
+		all      []EventKind
+		failures []EventKind
+	}{
+		// The first address is not a change
+		{newReport("192.0.2.2", report.Unchanged), nil, nil},
+		{newReport("192.0.2.3", report.Updated), []EventKind{IPChanged, RecordUpdated}, nil},
+		{newReport("192.0.2.3", report.Failed), nil, nil},
+		{newReport("192.0.2.3", report.Failed), []EventKind{Failure}, nil},
+		{newReport("192.0.2.3", report.Failed), nil, []EventKind{Failure}},
+		{newReport("192.0.2.3", report.Failed), nil, nil},
+		{newReport("192.0.2.3", report.Unchanged), []EventKind{Recovered}, []EventKind{Recovered}},
+		{newReport("192.0.2.3", report.Failed), nil, nil},
+		// Recovery is only notified after a failure notification
+		{newReport("192.0.2.3", report.Unchanged), nil, nil},
+	}
+	for i, step := range steps {
+		d.Observe(ctx, step.report)
+		if got := all.kinds(); !equalKinds(got, step.all) {
+			t.Errorf("Step %d: expected events %v, got %v", i, step.all, got)
+		}
+		if got := failures.kinds(); !equalKinds(got, step.failures) {
+			t.Errorf("Step %d: expected failure events %v, got %v", i, step.failures, got)
+		}
+	}
+
+	// Changes of the public IPv6 are notified as well
+	for i, expected := range [][]EventKind{nil, {IPChanged}} {
+		r := newReport("192.0.2.3", report.Unchanged)
+		r.ExternalIPv6 = fmt.Sprintf("2001:db8::%d", i+1)
+		d.Observe(ctx, r)
+		if got := all.kinds(); !equalKinds(got, expected) {
+			t.Errorf("IPv6 %s: expected events %v, got %v", r.ExternalIPv6, expected, got)
+		}
+	}
+}
+
+func TestConfigurationValidate(t *testing.T) {
+	invalid := []Configuration{
+		{Type: "pager", URL: "http://localhost"},
+		{Type: "webhook"},
+		{Type: "webhook", URL: "http://localhost", On: []EventKind{"ip_changd"}},
+		{Type: "webhook", URL: "http://localhost", Template: "{{.Kind"},
+		{Type: "email", SMTP: SMTPConfiguration{Host: "localhost", From: "ddns@example.com"}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected configuration %+v to be invalid", c)
+		}
+	}
+	d := NewDispatcher()
+	if err := d.Configure([]Configuration{{Type: "slack", URL: "http://localhost", On: []EventKind{Failure}}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(d.routes) != 1 || d.routes[0].threshold != defaultFailureThreshold || d.routes[0].on[IPChanged] {
+		t.Errorf("Unexpected routes %+v", d.routes)
+	}
+}
+
+var testEvent = Event{Kind: IPChanged, Title: "Public IP changed", Message: "Public ipv4 address changed from 192.0.2.1 to 192.0.2.2", Family: "ipv4", OldIP: "192.0.2.1", NewIP: "192.0.2.2"}
+
+// capture starts a server recording the last request it received
+func capture(t *testing.T, status int) (*httptest.Server, *http.Request, *[]byte) {
+	var request http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = *r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &request, &body
+}
+
+func TestWebhook(t *testing.T) {
+	server, request, body := capture(t, http.StatusOK)
+	n, err := New(Configuration{Type: "webhook", URL: server.URL + "/hook", Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var received Event
+	if err := json.Unmarshal(*body, &received); err != nil || received.NewIP != "192.0.2.2" || received.Kind != IPChanged {
+		t.Errorf("Unexpected body %s", *body)
+	}
+	if request.Method != "POST" || request.URL.Path != "/hook" || request.Header.Get("X-Token") != "secret" {
+		t.Errorf("Unexpected request %s %s %v", request.Method, request.URL.Path, request.Header)
+	}
+
+	n, _ = New(Configuration{Type: "webhook", URL: server.URL, Method: "PUT", Template: `{"ip": {{json .NewIP}}}`})
+	if err := n.Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(*body) != `{"ip": "192.0.2.2"}` || request.Method != "PUT" {
+		t.Errorf("Unexpected templated request %s %s", request.Method, *body)
+	}
+
+	failing, _, _ := capture(t, http.StatusInternalServerError)
+	n, _ = New(Configuration{Type: "webhook", URL: failing.URL})
+	err = n.Notify(context.Background(), testEvent)
+	if e, ok := err.(*ErrDeliveryFailed); !ok || e.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected ErrDeliveryFailed, got %v", err)
+	}
+}
+
+func TestChat(t *testing.T) {
+	server, _, body := capture(t, http.StatusOK)
+	n, _ := New(Configuration{Type: "matrix", URL: server.URL, Template: "IP is now {{.NewIP}}"})
+	if err := n.Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(*body, &payload); err != nil || payload["text"] != "IP is now 192.0.2.2" {
+		t.Errorf("Unexpected body %s", *body)
+	}
+}
+
+func TestPush(t *testing.T) {
+	server, request, body := capture(t, http.StatusOK)
+	n, _ := New(Configuration{Type: "ntfy", URL: server.URL + "/home-ddns", Token: "tk", Priority: 4})
+	if err := n.Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(*body) != testEvent.Message || request.URL.Path != "/home-ddns" || request.Header.Get("Title") != testEvent.Title ||
+		request.Header.Get("Priority") != "4" || request.Header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("Unexpected ntfy request %s %v", *body, request.Header)
+	}
+
+	n, _ = New(Configuration{Type: "gotify", URL: server.URL + "/", Token: "app", Priority: 5})
+	if err := n.Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var payload struct {
+		Title    string
+		Message  string
+		Priority int
+	}
+	if err := json.Unmarshal(*body, &payload); err != nil || payload.Message != testEvent.Message || payload.Priority != 5 {
+		t.Errorf("Unexpected gotify body %s", *body)
+	}
+	if request.URL.Path != "/message" || request.Header.Get("X-Gotify-Key") != "app" {
+		t.Errorf("Unexpected gotify request %s %v", request.URL.Path, request.Header)
+	}
+}
+
+// smtpServer is a minimal SMTP stand-in accepting a single message
+func smtpServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var envelope []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RCPT"):
+				envelope = append(envelope, strings.TrimSpace(line))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				messages <- strings.Join(envelope, "\n") + "\n" + data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestEmail(t *testing.T) {
+	addr, messages := smtpServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	n, err := New(Configuration{Type: "email", SMTP: SMTPConfiguration{Host: host, Port: portNumber, From: "ddns@example.com", To: []string{"ops@example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	message := <-messages
+	for _, expected := range []string{"MAIL FROM:<ddns@example.com>", "RCPT TO:<ops@example.com>", "Subject: home-ddns: Public IP changed", testEvent.Message} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Message does not contain %q:\n%s", expected, message)
+		}
+	}
+}
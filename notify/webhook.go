@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ErrDeliveryFailed is returned when the destination rejects a notification
+type ErrDeliveryFailed struct {
+	Type       string
+	StatusCode int
+}
+
+func (e *ErrDeliveryFailed) Error() string {
+	return fmt.Sprintf("%s notification rejected with status %d", e.Type, e.StatusCode)
+}
+
+// post sends an HTTP request, returning an error unless the response is successful
+func post(ctx context.Context, client *http.Client, kind, method, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &ErrDeliveryFailed{Type: kind, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhook posts events to a generic endpoint, as JSON or with a templated body
+type webhook struct {
+	url      string
+	method   string
+	headers  map[string]string
+	template *template.Template
+	client   *http.Client
+}
+
+func newWebhook(c Configuration, tmpl *template.Template) *webhook {
+	method := c.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	for name, value := range c.Headers {
+		headers[name] = value
+	}
+	return &webhook{url: c.URL, method: method, headers: headers, template: tmpl, client: &http.Client{}}
+}
+
+func (w *webhook) Notify(ctx context.Context, e Event) error {
+	var body []byte
+	var err error
+	if w.template == nil {
+		body, err = json.Marshal(e)
+	} else {
+		var b bytes.Buffer
+		err = w.template.Execute(&b, e)
+		body = b.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	return post(ctx, w.client, "webhook", w.method, w.url, w.headers, body)
+}
+
+// chat posts events to Slack-compatible incoming webhooks, also accepted by Matrix bridges
+type chat struct {
+	kind     string
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+func newChat(c Configuration, tmpl *template.Template) *chat {
+	return &chat{kind: c.Type, url: c.URL, template: tmpl, client: &http.Client{}}
+}
+
+func (c *chat) Notify(ctx context.Context, e Event) error {
+	text, err := render(c.template, e)
+	if err != nil {
+		return err
+	}
+	if c.template == nil {
+		text = fmt.Sprintf("*%s*\n%s", e.Title, text)
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(ctx, c.client, c.kind, http.MethodPost, c.url, map[string]string{"Content-Type": "application/json"}, body)
+}
+
+// ntfy publishes events to a ntfy topic, the URL includes the topic
+type ntfy struct {
+	url      string
+	token    string
+	priority int
+	template *template.Template
+	client   *http.Client
+}
+
+func newNtfy(c Configuration, tmpl *template.Template) *ntfy {
+	return &ntfy{url: c.URL, token: c.Token, priority: c.Priority, template: tmpl, client: &http.Client{}}
+}
+
+func (n *ntfy) Notify(ctx context.Context, e Event) error {
+	text, err := render(n.template, e)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Title": e.Title,
+		"Tags":  string(e.Kind),
+	}
+	if n.priority != 0 {
+		headers["Priority"] = strconv.Itoa(n.priority)
+	}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+	return post(ctx, n.client, "ntfy", http.MethodPost, n.url, headers, []byte(text))
+}
+
+// gotify sends events to the message endpoint of a Gotify server
+type gotify struct {
+	url      string
+	token    string
+	priority int
+	template *template.Template
+	client   *http.Client
+}
+
+func newGotify(c Configuration, tmpl *template.Template) *gotify {
+	return &gotify{url: strings.TrimSuffix(c.URL, "/"), token: c.Token, priority: c.Priority, template: tmpl, client: &http.Client{}}
+}
+
+func (g *gotify) Notify(ctx context.Context, e Event) error {
+	text, err := render(g.template, e)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority,omitempty"`
+	}{e.Title, text, g.priority})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"X-Gotify-Key": g.token,
+	}
+	return post(ctx, g.client, "gotify", http.MethodPost, g.url+"/message", headers, body)
+}
@@ -0,0 +1,249 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sudneo/home-ddns/rdata"
+	"github.com/sudneo/home-ddns/report"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultTimeout  = 5 * time.Minute
+	defaultInterval = 10 * time.Second
+	// Maximum duration of a single query to a nameserver
+	queryTimeout = 5 * time.Second
+)
+
+// Configuration of the verification of changes
+type Configuration struct {
+	Enabled bool `yaml:"enabled"`
+	// Time after which verification gives up (default 5m)
+	Timeout time.Duration `yaml:"timeout"`
+	// Delay between queries to nameservers which do not return the new value yet (default 10s)
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Validate checks the configuration
+func (c Configuration) Validate() error {
+	if c.Timeout < 0 || c.Interval < 0 {
+		return errors.New("propagation timeout and interval must not be negative")
+	}
+	return nil
+}
+
+// Verifier queries the authoritative nameservers of a zone until they serve a value
+type Verifier struct {
+	// LookupNS returns the addresses (host:port) of the authoritative nameservers of a zone
+	LookupNS func(ctx context.Context, zone string) ([]string, error)
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+func NewVerifier(c Configuration) *Verifier {
+	v := &Verifier{LookupNS: lookupNS, Timeout: c.Timeout, Interval: c.Interval}
+	if v.Timeout == 0 {
+		v.Timeout = defaultTimeout
+	}
+	if v.Interval == 0 {
+		v.Interval = defaultInterval
+	}
+	return v
+}
+
+// lookupNS finds the nameservers of a zone through the system resolver, preferring their IPv4 addresses
+func lookupNS(ctx context.Context, zone string) ([]string, error) {
+	nameservers, err := net.DefaultResolver.LookupNS(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, ns := range nameservers {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, ns.Host)
+		if err != nil {
+			return nil, err
+		}
+		var v4, v6 []string
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil {
+				v4 = append(v4, net.JoinHostPort(addr.IP.String(), "53"))
+			} else {
+				v6 = append(v6, net.JoinHostPort(addr.IP.String(), "53"))
+			}
+		}
+		if len(v4) > 0 {
+			servers = append(servers, v4...)
+		} else {
+			servers = append(servers, v6...)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameserver found for %s", zone)
+	}
+	return servers, nil
+}
+
+var queryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"SRV":   dnsmessage.TypeSRV,
+}
+
+// fqdn returns the fully qualified name of a record of a zone
+func fqdn(zone string, name string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	if name == "" || name == "@" {
+		return zone + "."
+	}
+	return name + "." + zone + "."
+}
+
+// matches compares a value served by a nameserver with the expected one, in their canonical form
+func matches(recordType string, zone string, expected string, served string) bool {
+	return rdata.Equal(recordType, strings.TrimSuffix(zone, "."), expected, served)
+}
+
+// query asks a nameserver for the values of a record
+func query(ctx context.Context, server string, name string, qtype dnsmessage.Type) ([]string, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	request := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	var response dnsmessage.Message
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray datagrams
+		if err := response.Unpack(buf[:n]); err == nil && response.ID == id && response.Response {
+			break
+		}
+	}
+	if response.RCode != dnsmessage.RCodeSuccess && response.RCode != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("nameserver answered %s", response.RCode)
+	}
+	var values []string
+	for _, answer := range response.Answers {
+		if answer.Header.Type != qtype {
+			continue
+		}
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			values = append(values, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			values = append(values, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			values = append(values, body.CNAME.String())
+		case *dnsmessage.TXTResource:
+			values = append(values, strings.Join(body.TXT, ""))
+		case *dnsmessage.MXResource:
+			values = append(values, body.MX.String())
+		case *dnsmessage.NSResource:
+			values = append(values, body.NS.String())
+		case *dnsmessage.SRVResource:
+			values = append(values, body.Target.String())
+		}
+	}
+	return values, nil
+}
+
+// Verify waits until every authoritative nameserver of the zone serves the expected value for the record,
+// or the timeout expires
+func (v *Verifier) Verify(ctx context.Context, zone string, name string, recordType string, expected string) *report.Propagation {
+	start := time.Now()
+	result := &report.Propagation{}
+	defer func() {
+		result.ElapsedSeconds = time.Since(start).Seconds()
+	}()
+	qtype, ok := queryTypes[recordType]
+	if !ok {
+		result.Status = report.Unverifiable
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+	servers, err := v.LookupNS(ctx, zone)
+	if err != nil {
+		result.Status = report.VerificationFailed
+		result.Error = err.Error()
+		return result
+	}
+	record := fqdn(zone, name)
+	answers := make([]report.ServerAnswer, len(servers))
+	for i, server := range servers {
+		answers[i].Server = server
+	}
+	result.Servers = answers
+	for {
+		pending := 0
+		for i := range answers {
+			if answers[i].Matches {
+				continue
+			}
+			values, err := query(ctx, answers[i].Server, record, qtype)
+			answers[i].Values = values
+			answers[i].Error = ""
+			if err != nil {
+				answers[i].Error = err.Error()
+			}
+			for _, value := range values {
+				answers[i].Matches = answers[i].Matches || matches(recordType, zone, expected, value)
+			}
+			if !answers[i].Matches {
+				pending++
+			}
+		}
+		if pending == 0 {
+			result.Status = report.Verified
+			log.WithFields(log.Fields{
+				"Record":  record,
+				"Elapsed": time.Since(start).Round(time.Second),
+			}).Info("Change served by every authoritative nameserver")
+			return result
+		}
+		select {
+		case <-ctx.Done():
+			result.Status = report.NotPropagated
+			log.WithFields(log.Fields{
+				"Record":  record,
+				"Pending": pending,
+			}).Warn("Change not served by every authoritative nameserver before the timeout")
+			return result
+		case <-time.After(v.Interval):
+		}
+	}
+}
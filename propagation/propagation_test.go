@@ -0,0 +1,140 @@
+package propagation
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sudneo/home-ddns/report"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// nameserver is an authoritative stand-in serving a single A record, whose address can be changed
+type nameserver struct {
+	mu      sync.Mutex
+	address [4]byte
+	queries int
+	conn    net.PacketConn
+}
+
+func startNameserver(t *testing.T, address [4]byte) *nameserver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := &nameserver{address: address, conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go ns.serve()
+	return ns
+}
+
+func (ns *nameserver) set(address [4]byte) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.address = address
+}
+
+func (ns *nameserver) count() int {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.queries
+}
+
+func (ns *nameserver) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := ns.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var request dnsmessage.Message
+		if err := request.Unpack(buf[:n]); err != nil || len(request.Questions) != 1 {
+			continue
+		}
+		ns.mu.Lock()
+		ns.queries++
+		address := ns.address
+		ns.mu.Unlock()
+		q := request.Questions[0]
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: request.ID, Response: true, Authoritative: true},
+			Questions: request.Questions,
+		}
+		if q.Type == dnsmessage.TypeA && q.Name.String() == "home.example.com." {
+			response.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 600},
+				Body:   &dnsmessage.AResource{A: address},
+			}}
+		} else {
+			response.RCode = dnsmessage.RCodeNameError
+		}
+		packed, _ := response.Pack()
+		ns.conn.WriteTo(packed, addr)
+	}
+}
+
+func verifier(servers ...*nameserver) *Verifier {
+	return &Verifier{
+		LookupNS: func(ctx context.Context, zone string) ([]string, error) {
+			var addrs []string
+			for _, ns := range servers {
+				addrs = append(addrs, ns.conn.LocalAddr().String())
+			}
+			return addrs, nil
+		},
+		Timeout:  2 * time.Second,
+		Interval: 20 * time.Millisecond,
+	}
+}
+
+func TestVerify(t *testing.T) {
+	updated := startNameserver(t, [4]byte{192, 0, 2, 2})
+	lagging := startNameserver(t, [4]byte{192, 0, 2, 1})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lagging.set([4]byte{192, 0, 2, 2})
+	}()
+	result := verifier(updated, lagging).Verify(context.Background(), "example.com", "home", "A", "192.0.2.2")
+	if result.Status != report.Verified || len(result.Servers) != 2 {
+		t.Fatalf("Expected the change to be verified, got %+v", result)
+	}
+	if updated.count() != 1 {
+		t.Errorf("Nameserver serving the new value queried %d times", updated.count())
+	}
+	if lagging.count() < 2 {
+		t.Errorf("Lagging nameserver queried only %d times", lagging.count())
+	}
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	ns := startNameserver(t, [4]byte{192, 0, 2, 1})
+	v := verifier(ns)
+	v.Timeout = 100 * time.Millisecond
+	result := v.Verify(context.Background(), "example.com", "home", "A", "192.0.2.2")
+	if result.Status != report.NotPropagated || result.Servers[0].Matches || len(result.Servers[0].Values) != 1 || result.Servers[0].Values[0] != "192.0.2.1" {
+		t.Errorf("Expected the verification to time out, got %+v", result)
+	}
+	if result := v.Verify(context.Background(), "example.com", "home", "CAA", "0 issue \"ca.example\""); result.Status != report.Unverifiable {
+		t.Errorf("Expected CAA records to be unsupported, got %+v", result)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		recordType, expected, served string
+		match                        bool
+	}{
+		{"AAAA", "2001:db8:0::1", "2001:db8::1", true},
+		{"A", "192.0.2.1", "192.0.2.2", false},
+		{"CNAME", "@", "example.com.", true},
+		{"CNAME", "Target.Example.net", "target.example.net.", true},
+		{"TXT", `"v=spf1 -all"`, "v=spf1 -all", true},
+	}
+	for _, c := range cases {
+		if matches(c.recordType, "example.com", c.expected, c.served) != c.match {
+			t.Errorf("Expected match of %s %s with %s to be %v", c.recordType, c.expected, c.served, c.match)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package rdata
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sudneo/home-ddns/models"
+)
+
+// Longest character string of a TXT record, longer values are split in several strings
+const maxTXTString = 255
+
+var (
+	// Labels of host names, underscores are allowed for SRV and TXT records such as _sip._tcp or _dmarc
+	labelPattern = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?$`)
+	// Service and protocol labels of SRV records, e.g. _sip and _tcp
+	srvLabelPattern = regexp.MustCompile(`^_[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$`)
+	caaPattern      = regexp.MustCompile(`^(\d+)\s+([A-Za-z0-9]+)\s+(.*)$`)
+)
+
+// FieldError is a problem of a field of a record other than its value
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// ValidHostname checks a fully qualified or relative host name, a trailing dot is allowed
+func ValidHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !labelPattern.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize checks the value of a record of a type and returns its canonical form, so that values
+// written differently by the configuration and the providers compare equal: IP addresses are compressed,
+// host names lower case without trailing dot, TXT strings unquoted and joined, CAA values quoted.
+// The domain replaces @ in host names and qualifies the relative ones, names of a single label without
+// trailing dot such as home. Both are kept as they are when the domain is empty
+func Normalize(recordType string, domain string, value string) (string, error) {
+	switch recordType {
+	case "A":
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() == nil {
+			return "", fmt.Errorf("value %q of A record is not an IPv4 address", value)
+		}
+		return ip.To4().String(), nil
+	case "AAAA":
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return "", fmt.Errorf("value %q of AAAA record is not an IPv6 address", value)
+		}
+		return ip.String(), nil
+	case "CNAME", "MX", "NS", "SRV":
+		// A single dot is the target of SRV records of unavailable services
+		if recordType == "SRV" && value == "." {
+			return value, nil
+		}
+		if value == "@" {
+			if domain == "" {
+				return value, nil
+			}
+			value = domain
+		}
+		if !ValidHostname(value) {
+			return "", fmt.Errorf("value %q of %s record is not a host name", value, recordType)
+		}
+		if domain != "" && !strings.Contains(value, ".") {
+			value += "." + domain
+		}
+		return strings.ToLower(strings.TrimSuffix(value, ".")), nil
+	case "TXT":
+		return normalizeTXT(value)
+	case "CAA":
+		return normalizeCAA(value)
+	}
+	return value, nil
+}
+
+// normalizeTXT joins the character strings of a TXT value written as quoted strings, e.g. "v=spf1 " "-all".
+// Unquoted values are taken as they are, see SplitTXT for the ones longer than a string
+func normalizeTXT(value string) (string, error) {
+	rest := strings.TrimSpace(value)
+	if !strings.HasPrefix(rest, `"`) {
+		return value, nil
+	}
+	var joined strings.Builder
+	for n := 1; rest != ""; n++ {
+		if rest[0] != '"' {
+			return "", fmt.Errorf("value %q of TXT record mixes quoted and unquoted text", value)
+		}
+		s, length, err := unquote(rest)
+		if err != nil {
+			return "", fmt.Errorf("value %q of TXT record: %s", value, err)
+		}
+		if len(s) > maxTXTString {
+			return "", fmt.Errorf("string %d of TXT record is %d bytes long, the maximum is %d", n, len(s), maxTXTString)
+		}
+		joined.WriteString(s)
+		rest = strings.TrimLeft(rest[length:], " \t")
+	}
+	return joined.String(), nil
+}
+
+// SplitTXT writes an unquoted TXT value longer than 255 bytes as quoted strings of 255 bytes, e.g. a long
+// DKIM key, which cannot be stored in a single string. Other values are returned as they are
+func SplitTXT(value string) string {
+	if len(value) <= maxTXTString || strings.HasPrefix(strings.TrimSpace(value), `"`) {
+		return value
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var strs []string
+	for len(value) > maxTXTString {
+		strs = append(strs, `"`+escaper.Replace(value[:maxTXTString])+`"`)
+		value = value[maxTXTString:]
+	}
+	strs = append(strs, `"`+escaper.Replace(value)+`"`)
+	return strings.Join(strs, " ")
+}
+
+// unquote reads the quoted string at the start of a text, returning its content and the length of the text it spans
+func unquote(text string) (string, int, error) {
+	var s strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '"':
+			return s.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(text) {
+				return "", 0, fmt.Errorf("unterminated escape sequence")
+			}
+			i++
+		}
+		s.WriteByte(text[i])
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+// normalizeCAA checks a CAA value in the form flags tag "value", e.g. 0 issue "letsencrypt.org"
+func normalizeCAA(value string) (string, error) {
+	invalid := fmt.Errorf(`value %q of CAA record is not in the form flags tag "value", e.g. 0 issue "letsencrypt.org"`, value)
+	match := caaPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return "", invalid
+	}
+	flags, err := strconv.Atoi(match[1])
+	if err != nil || flags > 255 {
+		return "", invalid
+	}
+	content := match[3]
+	if strings.HasPrefix(content, `"`) {
+		s, length, err := unquote(content)
+		if err != nil || length != len(content) {
+			return "", invalid
+		}
+		content = s
+	} else if strings.ContainsAny(content, " \t\"") {
+		return "", invalid
+	}
+	return fmt.Sprintf("%d %s %q", flags, strings.ToLower(match[2]), content), nil
+}
+
+// Equal reports whether two values of a record type are the same once normalized, values which
+// cannot be normalized are compared as they are
+func Equal(recordType string, domain string, a string, b string) bool {
+	if a == b {
+		return true
+	}
+	normalizedA, errA := Normalize(recordType, domain, a)
+	normalizedB, errB := Normalize(recordType, domain, b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return normalizedA == normalizedB
+}
+
+// Check validates the fields besides the value required by the type of a record, the priority of MX records
+// and the service, protocol, weight and port of SRV records. A priority or weight of 0 is valid, but must be set
+func Check(record models.DNSRecord) []*FieldError {
+	var errs []*FieldError
+	switch record.Type {
+	case "MX":
+		if record.Priority == nil {
+			errs = append(errs, &FieldError{Field: "priority", Message: "priority is required for MX records, e.g. 10"})
+		}
+	case "SRV":
+		for _, label := range []struct {
+			field   string
+			value   string
+			example string
+		}{
+			{"service", record.Service, "_sip"},
+			{"protocol", record.Protocol, "_tcp"},
+		} {
+			if label.value == "" {
+				errs = append(errs, &FieldError{Field: label.field, Message: fmt.Sprintf("%s is required for SRV records, e.g. %s", label.field, label.example)})
+			} else if !srvLabelPattern.MatchString(label.value) {
+				errs = append(errs, &FieldError{Field: label.field, Message: fmt.Sprintf("invalid %s %q of SRV record, expected e.g. %s", label.field, label.value, label.example)})
+			}
+		}
+		if record.Weight == nil {
+			errs = append(errs, &FieldError{Field: "weight", Message: "weight is required for SRV records, e.g. 0 when there is a single target"})
+		}
+		if record.Port == 0 {
+			errs = append(errs, &FieldError{Field: "port", Message: "port is required for SRV records"})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package rdata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sudneo/home-ddns/models"
+)
+
+func TestNormalize(t *testing.T) {
+	normalized := []struct {
+		recordType, value, expected string
+	}{
+		{"A", "192.0.2.1", "192.0.2.1"},
+		{"AAAA", "2001:DB8:0:0::0:1", "2001:db8::1"},
+		{"CNAME", "Target.Example.net.", "target.example.net"},
+		{"CNAME", "@", "example.com"},
+		{"CNAME", "Home", "home.example.com"},
+		{"CNAME", "localhost.", "localhost"},
+		{"MX", "MAIL.example.com", "mail.example.com"},
+		{"SRV", ".", "."},
+		{"TXT", "v=spf1 -all", "v=spf1 -all"},
+		{"TXT", `"v=spf1 " "ip4:192.0.2.1 -all"`, "v=spf1 ip4:192.0.2.1 -all"},
+		{"TXT", `"say \"hi\""`, `say "hi"`},
+		{"CAA", `0 ISSUE "letsencrypt.org"`, `0 issue "letsencrypt.org"`},
+		{"CAA", `128  iodef mailto:security@example.com`, `128 iodef "mailto:security@example.com"`},
+	}
+	for _, n := range normalized {
+		value, err := Normalize(n.recordType, "example.com", n.value)
+		if err != nil {
+			t.Errorf("Normalizing %s %q lead to error: %s", n.recordType, n.value, err)
+		} else if value != n.expected {
+			t.Errorf("Normalizing %s %q gave %q, expected %q", n.recordType, n.value, value, n.expected)
+		}
+	}
+
+	invalid := map[string][2]string{
+		"is not an IPv4 address":                   {"A", "2001:db8::1"},
+		"is not an IPv6 address":                   {"AAAA", "192.0.2.1"},
+		"is not a host name":                       {"NS", "ns1 example.com"},
+		"unterminated quoted":                      {"TXT", `"v=spf1 -all`},
+		"mixes quoted and unquoted":                {"TXT", `"v=spf1" -all`},
+		"string 2 of TXT record is 256 bytes long": {"TXT", `"a" "` + strings.Repeat("a", 256) + `"`},
+		"is not in the form flags tag":             {"CAA", `256 issue "letsencrypt.org"`},
+	}
+	for expected, record := range invalid {
+		if _, err := Normalize(record[0], "example.com", record[1]); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q normalizing %s %q, got %v", expected, record[0], record[1], err)
+		}
+	}
+	// Without a domain @ and relative names are kept
+	if value, err := Normalize("CNAME", "", "@"); err != nil || value != "@" {
+		t.Errorf("Unexpected normalization of @ without domain %q, %v", value, err)
+	}
+	if value, err := Normalize("CNAME", "", "home"); err != nil || value != "home" {
+		t.Errorf("Unexpected normalization of a relative name without domain %q, %v", value, err)
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	long := strings.Repeat("a", 254) + `"` + strings.Repeat("b", 300)
+	split := SplitTXT(long)
+	expected := `"` + strings.Repeat("a", 254) + `\""` + ` "` + strings.Repeat("b", 255) + `" "` + strings.Repeat("b", 45) + `"`
+	if split != expected {
+		t.Errorf("Unexpected split of a long TXT value %q", split)
+	}
+	// The strings are joined back to the original value
+	if value, err := Normalize("TXT", "example.com", split); err != nil || value != long {
+		t.Errorf("Split TXT value normalized to %q, %v", value, err)
+	}
+	for _, value := range []string{"v=spf1 -all", strings.Repeat("a", 255), `"` + strings.Repeat("a", 255) + `" "b"`} {
+		if SplitTXT(value) != value {
+			t.Errorf("TXT value %q split", value)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !Equal("CNAME", "example.com", "@", "EXAMPLE.com.") || !Equal("TXT", "example.com", `"v=spf1 -all"`, "v=spf1 -all") ||
+		!Equal("CNAME", "test.com", "home", "home.test.com.") {
+		t.Errorf("Values written differently are not equal")
+	}
+	if Equal("A", "example.com", "192.0.2.1", "192.0.2.2") || Equal("A", "example.com", "home", "192.0.2.1") {
+		t.Errorf("Different values are equal")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	zero := 0
+	if errs := Check(models.DNSRecord{Type: "SRV", Service: "_sip", Protocol: "_tcp", Weight: &zero, Port: 5060}); len(errs) != 0 {
+		t.Errorf("Valid SRV record lead to errors: %v", errs)
+	}
+	errs := Check(models.DNSRecord{Type: "SRV", Service: "sip"})
+	fields := make([]string, len(errs))
+	for i, err := range errs {
+		fields[i] = err.Field
+	}
+	if strings.Join(fields, ",") != "service,protocol,weight,port" {
+		t.Errorf("Unexpected SRV errors %v", errs)
+	}
+	// A priority of 0 is valid, but it must be set
+	if errs := Check(models.DNSRecord{Type: "MX", Priority: &zero}); len(errs) != 0 {
+		t.Errorf("MX record with priority 0 lead to errors: %v", errs)
+	}
+	if errs := Check(models.DNSRecord{Type: "MX"}); len(errs) != 1 || errs[0].Field != "priority" {
+		t.Errorf("MX record without priority lead to errors %v", errs)
+	}
+}
@@ -0,0 +1,185 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Outcome of the processing of a single DNS record
+type Outcome string
+
+const (
+	Created   Outcome = "created"
+	Updated   Outcome = "updated"
+	Unchanged Outcome = "unchanged"
+	Failed    Outcome = "failed"
+	Skipped   Outcome = "skipped"
+)
+
+// Change attempted on a record at the provider
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+)
+
+// Outcome of the verification of a change against the authoritative nameservers
+type PropagationStatus string
+
+const (
+	// Every authoritative nameserver returns the new value
+	Verified PropagationStatus = "verified"
+	// Some nameservers still did not return the new value when the timeout expired
+	NotPropagated PropagationStatus = "timeout"
+	// The authoritative nameservers could not be found
+	VerificationFailed PropagationStatus = "failed"
+	// The record type cannot be verified
+	Unverifiable PropagationStatus = "unsupported"
+)
+
+// ServerAnswer is the last answer of an authoritative nameserver
+type ServerAnswer struct {
+	Server  string   `json:"server"`
+	Values  []string `json:"values,omitempty"`
+	Matches bool     `json:"matches"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Propagation describes the verification of a change
+type Propagation struct {
+	Status PropagationStatus `json:"status"`
+	// Time it took for the change to be served everywhere, or until verification gave up
+	ElapsedSeconds float64        `json:"elapsed_seconds"`
+	Servers        []ServerAnswer `json:"servers,omitempty"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// Overall status of an execution
+type Status string
+
+const (
+	Success          Status = "success"
+	PartialFailure   Status = "partial_failure"
+	DiscoveryFailure Status = "discovery_failure"
+)
+
+type RecordResult struct {
+	Provider string  `json:"provider"`
+	Account  string  `json:"account"`
+	Domain   string  `json:"domain"`
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Outcome  Outcome `json:"outcome"`
+	// Set when a change was attempted, whatever its outcome
+	Action Action `json:"action,omitempty"`
+	// Value the record should have according to the configuration
+	Desired string `json:"desired,omitempty"`
+	// Value found at the provider before any change
+	Observed string `json:"observed,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Values of the configuration changed to fit the provider, e.g. a TTL below its minimum
+	Warnings []string `json:"warnings,omitempty"`
+	// Set when the change was verified against the authoritative nameservers
+	Propagation *Propagation `json:"propagation,omitempty"`
+}
+
+// Report collects the results of a single execution
+type Report struct {
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	ExternalIP string    `json:"external_ip,omitempty"`
+	// Public IPv6 address, when the host has IPv6 connectivity
+	ExternalIPv6 string `json:"external_ipv6,omitempty"`
+	// Set when the public IP could not be discovered, in which case no record is processed
+	DiscoveryError string         `json:"discovery_error,omitempty"`
+	Results        []RecordResult `json:"results"`
+}
+
+func New() *Report {
+	return &Report{Started: time.Now(), Results: []RecordResult{}}
+}
+
+// Add appends the result of a record to the report
+func (r *Report) Add(result RecordResult) {
+	r.Results = append(r.Results, result)
+}
+
+// Finish marks the end of the execution
+func (r *Report) Finish() {
+	r.Finished = time.Now()
+}
+
+// Counts returns the number of records for each outcome
+func (r *Report) Counts() map[Outcome]int {
+	counts := make(map[Outcome]int)
+	for _, result := range r.Results {
+		counts[result.Outcome]++
+	}
+	return counts
+}
+
+// Status summarizes the execution: records failed or skipped make it a partial failure
+func (r *Report) Status() Status {
+	if r.DiscoveryError != "" {
+		return DiscoveryFailure
+	}
+	counts := r.Counts()
+	if counts[Failed] > 0 || counts[Skipped] > 0 {
+		return PartialFailure
+	}
+	return Success
+}
+
+// WriteJSON prints the full report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		*Report
+		Status Status          `json:"status"`
+		Counts map[Outcome]int `json:"counts"`
+	}{r, r.Status(), r.Counts()})
+}
+
+// WriteTable prints the report as a human readable table followed by a summary line
+func (r *Report) WriteTable(w io.Writer) error {
+	if len(r.Results) > 0 {
+		// The propagation column is only shown when changes were verified
+		verified := false
+		for _, result := range r.Results {
+			verified = verified || result.Propagation != nil
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		if verified {
+			fmt.Fprintln(tw, "ACCOUNT\tDOMAIN\tNAME\tTYPE\tOUTCOME\tVALUE\tPROPAGATION\tERROR")
+		} else {
+			fmt.Fprintln(tw, "ACCOUNT\tDOMAIN\tNAME\tTYPE\tOUTCOME\tVALUE\tERROR")
+		}
+		for _, result := range r.Results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t", result.Account, result.Domain, result.Name, result.Type, result.Outcome, result.Desired)
+			if verified {
+				propagation := ""
+				if result.Propagation != nil {
+					propagation = fmt.Sprintf("%s (%.0fs)", result.Propagation.Status, result.Propagation.ElapsedSeconds)
+				}
+				fmt.Fprintf(tw, "%s\t", propagation)
+			}
+			fmt.Fprintf(tw, "%s\n", result.Error)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if r.DiscoveryError != "" {
+		fmt.Fprintf(w, "Public IP discovery failed: %s\n", r.DiscoveryError)
+	}
+	counts := r.Counts()
+	_, err := fmt.Fprintf(w, "%s: %d created, %d updated, %d unchanged, %d failed, %d skipped in %s\n",
+		r.Status(), counts[Created], counts[Updated], counts[Unchanged], counts[Failed], counts[Skipped],
+		r.Finished.Sub(r.Started).Round(time.Millisecond))
+	return err
+}
@@ -0,0 +1,62 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	r := New()
+	r.Add(RecordResult{Name: "home", Outcome: Created})
+	r.Add(RecordResult{Name: "vpn", Outcome: Unchanged})
+	if r.Status() != Success {
+		t.Errorf("Expected success, got %s", r.Status())
+	}
+	r.Add(RecordResult{Name: "mail", Outcome: Failed, Error: "API call failed"})
+	if r.Status() != PartialFailure {
+		t.Errorf("Expected partial failure, got %s", r.Status())
+	}
+	r.DiscoveryError = "timeout"
+	if r.Status() != DiscoveryFailure {
+		t.Errorf("Expected discovery failure, got %s", r.Status())
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := New()
+	r.Add(RecordResult{Account: "Godaddy", Domain: "example.com", Name: "home", Type: "A", Outcome: Updated, Desired: "1.2.3.4"})
+	r.Add(RecordResult{Account: "Godaddy", Domain: "example.com", Name: "vpn", Type: "CNAME", Outcome: Skipped, Error: "context canceled"})
+	r.Finish()
+	var table bytes.Buffer
+	if err := r.WriteTable(&table); err != nil {
+		t.Fatalf("Writing the table lead to error: %s", err)
+	}
+	if !strings.Contains(table.String(), "1 updated") || !strings.Contains(table.String(), "1 skipped") {
+		t.Errorf("Summary missing from table:\n%s", table.String())
+	}
+	if strings.Contains(table.String(), "PROPAGATION") {
+		t.Errorf("Propagation column shown without verification:\n%s", table.String())
+	}
+	r.Results[0].Propagation = &Propagation{Status: Verified, ElapsedSeconds: 42}
+	table.Reset()
+	r.WriteTable(&table)
+	if !strings.Contains(table.String(), "PROPAGATION") || !strings.Contains(table.String(), "verified (42s)") {
+		t.Errorf("Propagation missing from table:\n%s", table.String())
+	}
+	var output bytes.Buffer
+	if err := r.WriteJSON(&output); err != nil {
+		t.Fatalf("Writing the JSON lead to error: %s", err)
+	}
+	var decoded struct {
+		Status  Status         `json:"status"`
+		Results []RecordResult `json:"results"`
+	}
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("Report is not valid JSON: %s", err)
+	}
+	if decoded.Status != PartialFailure || len(decoded.Results) != 2 {
+		t.Errorf("Unexpected JSON report: %s", output.String())
+	}
+}
@@ -0,0 +1,132 @@
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Standard 5 fields expressions, with an optional leading seconds field, and descriptors such as @daily or @every 90s
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Schedule computes the activation times of a cron expression, delayed by a random jitter
+type Schedule struct {
+	expression string
+	jitter     time.Duration
+	spec       cron.Schedule
+}
+
+// Parse validates a cron expression. A positive jitter delays every activation by a random duration up to its value
+func Parse(expression string, jitter time.Duration) (*Schedule, error) {
+	if jitter < 0 {
+		return nil, fmt.Errorf("jitter must not be negative")
+	}
+	spec, err := parser.Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &Schedule{expression: expression, jitter: jitter, spec: spec}, nil
+}
+
+// Next returns the first activation after the given time
+func (s *Schedule) Next(after time.Time) time.Time {
+	return s.spec.Next(after).Add(s.delay())
+}
+
+// delay returns a random duration up to the jitter
+func (s *Schedule) delay() time.Duration {
+	if s.jitter > 0 {
+		return time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+	return 0
+}
+
+func (s *Schedule) String() string {
+	if s.jitter > 0 {
+		return fmt.Sprintf("%s (jitter %s)", s.expression, s.jitter)
+	}
+	return s.expression
+}
+
+// Entry associates a key, identifying what is scheduled, with its schedule
+type Entry struct {
+	Key      string
+	Schedule *Schedule
+}
+
+type scheduled struct {
+	Entry
+	// Occurrence of the schedule, and its activation delayed by the jitter
+	nominal time.Time
+	next    time.Time
+}
+
+// plan sets the occurrence of an entry, drawing the jitter of its activation
+func (e *scheduled) plan(nominal time.Time) {
+	e.nominal = nominal
+	e.next = nominal.Add(e.Schedule.delay())
+}
+
+// Scheduler keeps track of the next activation of a set of entries
+type Scheduler struct {
+	entries []*scheduled
+}
+
+// NewScheduler schedules every entry starting from the given time
+func NewScheduler(entries []Entry, now time.Time) *Scheduler {
+	s := &Scheduler{}
+	s.Update(entries, now)
+	return s
+}
+
+// Update replaces the entries. Entries whose key and schedule did not change keep their next activation
+func (s *Scheduler) Update(entries []Entry, now time.Time) {
+	previous := make(map[string]*scheduled)
+	for _, e := range s.entries {
+		previous[e.Key] = e
+	}
+	s.entries = make([]*scheduled, 0, len(entries))
+	for _, entry := range entries {
+		if e, ok := previous[entry.Key]; ok && e.Schedule.String() == entry.Schedule.String() {
+			s.entries = append(s.entries, e)
+			continue
+		}
+		e := &scheduled{Entry: entry}
+		e.plan(entry.Schedule.spec.Next(now))
+		s.entries = append(s.entries, e)
+	}
+}
+
+// Next returns the earliest activation among the entries, zero when there are none
+func (s *Scheduler) Next() time.Time {
+	var next time.Time
+	for _, e := range s.entries {
+		if next.IsZero() || e.next.Before(next) {
+			next = e.next
+		}
+	}
+	return next
+}
+
+// Due returns the sorted keys of the entries whose activation is not after now, and schedules their next one.
+// The next occurrence follows the previous one rather than the delayed activation, so that the jitter does not
+// accumulate. Occurrences missed altogether, e.g. while the host was suspended, are skipped
+func (s *Scheduler) Due(now time.Time) []string {
+	var keys []string
+	for _, e := range s.entries {
+		if e.next.After(now) {
+			continue
+		}
+		keys = append(keys, e.Key)
+		nominal := e.Schedule.spec.Next(e.nominal)
+		if !nominal.After(now) {
+			nominal = e.Schedule.spec.Next(now)
+		}
+		e.plan(nominal)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC)
+	cases := []struct {
+		expression string
+		next       time.Time
+	}{
+		{"*/5 * * * *", time.Date(2024, 1, 1, 10, 5, 0, 0, time.UTC)},
+		{"15 */5 * * * *", time.Date(2024, 1, 1, 10, 5, 15, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 1, 1, 10, 2, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expression, 0)
+		if err != nil {
+			t.Errorf("Parsing %s lead to error: %s", c.expression, err)
+			continue
+		}
+		if next := s.Next(start); !next.Equal(c.next) {
+			t.Errorf("%s: expected next activation at %s, got %s", c.expression, c.next, next)
+		}
+	}
+	for _, invalid := range []string{"", "* * *", "@every soon", "61 * * * *"} {
+		if _, err := Parse(invalid, 0); err == nil {
+			t.Errorf("Invalid expression %q did not error", invalid)
+		}
+	}
+	s, _ := Parse("0 * * * *", 10*time.Minute)
+	for i := 0; i < 20; i++ {
+		next := s.Next(start)
+		if next.Before(time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)) || !next.Before(time.Date(2024, 1, 1, 11, 10, 0, 0, time.UTC)) {
+			t.Errorf("Jittered activation %s out of range", next)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	fast, _ := Parse("@every 1m", 0)
+	slow, _ := Parse("@daily", 0)
+	s := NewScheduler([]Entry{{Key: "a-records", Schedule: fast}, {Key: "cnames", Schedule: slow}}, now)
+	if next := s.Next(); !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected next activation in a minute, got %s", next)
+	}
+	due := s.Due(now.Add(time.Minute))
+	if len(due) != 1 || due[0] != "a-records" {
+		t.Errorf("Expected only the fast entry to be due, got %v", due)
+	}
+	due = s.Due(now.Add(24 * time.Hour))
+	if len(due) != 2 {
+		t.Errorf("Expected both entries to be due, got %v", due)
+	}
+	// Unchanged entries keep their activation across updates
+	next := s.Next()
+	s.Update([]Entry{{Key: "a-records", Schedule: fast}}, now.Add(25*time.Hour))
+	if !s.Next().Equal(next) {
+		t.Errorf("Unchanged entry was rescheduled from %s to %s", next, s.Next())
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	jittered, _ := Parse("@every 1m", 50*time.Second)
+	s := NewScheduler([]Entry{{Key: "a-records", Schedule: jittered}}, now)
+	// Each activation is delayed from its own occurrence, the delays do not add up
+	for i := 1; i <= 100; i++ {
+		next := s.Next()
+		occurrence := now.Add(time.Duration(i) * time.Minute)
+		if next.Before(occurrence) || !next.Before(occurrence.Add(50*time.Second)) {
+			t.Fatalf("Activation %d at %s, expected within 50s of %s", i, next, occurrence)
+		}
+		if due := s.Due(next); len(due) != 1 {
+			t.Fatalf("Expected the entry to be due at %s, got %v", next, due)
+		}
+	}
+	// Occurrences missed while the host was suspended are skipped
+	late := s.Next().Add(time.Hour)
+	s.Due(late)
+	if next := s.Next(); !next.After(late) || next.After(late.Add(2*time.Minute)) {
+		t.Errorf("Expected the next activation within 2 minutes after %s, got %s", late, next)
+	}
+}
@@ -0,0 +1,199 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sudneo/home-ddns/report"
+	"github.com/sudneo/home-ddns/utils"
+)
+
+// RecordState is the last known state of a configured record
+type RecordState struct {
+	Provider string         `json:"provider"`
+	Account  string         `json:"account"`
+	Domain   string         `json:"domain"`
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`
+	Outcome  report.Outcome `json:"outcome"`
+	// Value the record should have according to the configuration
+	Desired string `json:"desired,omitempty"`
+	// Value served by the provider after the last execution
+	Observed    string    `json:"observed,omitempty"`
+	LastChecked time.Time `json:"last_checked"`
+	// Last error seen for this record, kept after the record recovers
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Status is a snapshot of the state of the daemon
+type Status struct {
+	IPs                 map[string]string `json:"ips"`
+	LastRun             *time.Time        `json:"last_run,omitempty"`
+	LastRunStatus       report.Status     `json:"last_run_status,omitempty"`
+	LastRunError        string            `json:"last_run_error,omitempty"`
+	NextRun             *time.Time        `json:"next_run,omitempty"`
+	ConsecutiveFailures int               `json:"consecutive_failures"`
+	Ready               bool              `json:"ready"`
+	Records             []RecordState     `json:"records"`
+}
+
+// Tracker keeps the state of the daemon across executions
+type Tracker struct {
+	mu sync.Mutex
+	// Number of consecutive failed executions after which the daemon is not ready
+	failureThreshold    int
+	ips                 map[string]string
+	lastRun             time.Time
+	lastRunStatus       report.Status
+	lastRunError        string
+	nextRun             time.Time
+	consecutiveFailures int
+	records             map[string]*RecordState
+}
+
+func NewTracker(failureThreshold int) *Tracker {
+	return &Tracker{
+		failureThreshold: failureThreshold,
+		ips:              make(map[string]string),
+		records:          make(map[string]*RecordState),
+	}
+}
+
+func recordKey(result report.RecordResult) string {
+	return fmt.Sprintf("%s/%s/%s/%s", result.Account, result.Domain, result.Name, result.Type)
+}
+
+// Observe updates the state with the outcome of an execution
+func (t *Tracker) Observe(r *report.Report) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastRun = r.Finished
+	t.lastRunStatus = r.Status()
+	t.lastRunError = r.DiscoveryError
+	if t.lastRunStatus == report.Success {
+		t.consecutiveFailures = 0
+	} else {
+		t.consecutiveFailures++
+	}
+	for _, address := range []string{r.ExternalIP, r.ExternalIPv6} {
+		if address != "" {
+			t.ips[utils.IPFamily(address)] = address
+		}
+	}
+	for _, result := range r.Results {
+		key := recordKey(result)
+		state, ok := t.records[key]
+		if !ok {
+			state = &RecordState{
+				Provider: result.Provider,
+				Account:  result.Account,
+				Domain:   result.Domain,
+				Name:     result.Name,
+				Type:     result.Type,
+			}
+			t.records[key] = state
+		}
+		state.Outcome = result.Outcome
+		state.Desired = result.Desired
+		state.LastChecked = r.Finished
+		switch result.Outcome {
+		case report.Created, report.Updated:
+			state.Observed = result.Desired
+		case report.Unchanged, report.Failed:
+			if result.Observed != "" {
+				state.Observed = result.Observed
+			}
+		}
+		if result.Error != "" {
+			at := r.Finished
+			state.LastError = result.Error
+			state.LastErrorAt = &at
+		}
+	}
+}
+
+// SetNextRun records when the next execution is scheduled
+func (t *Tracker) SetNextRun(next time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextRun = next
+}
+
+// ready must be called with the lock held
+func (t *Tracker) ready() bool {
+	return !t.lastRun.IsZero() && t.consecutiveFailures < t.failureThreshold
+}
+
+// Ready reports whether at least one execution completed and the last executions did not all fail
+func (t *Tracker) Ready() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ready()
+}
+
+// Snapshot returns a copy of the current state
+func (t *Tracker) Snapshot() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Status{
+		IPs:                 make(map[string]string),
+		LastRunStatus:       t.lastRunStatus,
+		LastRunError:        t.lastRunError,
+		ConsecutiveFailures: t.consecutiveFailures,
+		Ready:               t.ready(),
+		Records:             make([]RecordState, 0, len(t.records)),
+	}
+	for family, address := range t.ips {
+		s.IPs[family] = address
+	}
+	if !t.lastRun.IsZero() {
+		lastRun := t.lastRun
+		s.LastRun = &lastRun
+	}
+	if !t.nextRun.IsZero() {
+		nextRun := t.nextRun
+		s.NextRun = &nextRun
+	}
+	keys := make([]string, 0, len(t.records))
+	for key := range t.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.Records = append(s.Records, *t.records[key])
+	}
+	return s
+}
+
+// HealthHandler answers liveness probes, the process is alive as long as it can serve requests
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadyHandler answers readiness probes, failing when the daemon is not ready
+func (t *Tracker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// StatusHandler serves the current state as JSON
+func (t *Tracker) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(t.Snapshot())
+	})
+}
@@ -0,0 +1,68 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sudneo/home-ddns/report"
+)
+
+func failedReport() *report.Report {
+	r := report.New()
+	r.Add(report.RecordResult{Account: "Godaddy", Domain: "example.com", Name: "home", Type: "A", Outcome: report.Failed, Desired: "192.0.2.2", Observed: "192.0.2.1", Error: "API call failed"})
+	r.Finish()
+	return r
+}
+
+func TestReadiness(t *testing.T) {
+	tracker := NewTracker(2)
+	probe := func() int {
+		w := httptest.NewRecorder()
+		tracker.ReadyHandler().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	if probe() != http.StatusServiceUnavailable {
+		t.Errorf("Tracker ready before the first execution")
+	}
+	tracker.Observe(failedReport())
+	if probe() != http.StatusOK {
+		t.Errorf("Tracker not ready after a single failure")
+	}
+	tracker.Observe(failedReport())
+	if probe() != http.StatusServiceUnavailable {
+		t.Errorf("Tracker still ready after reaching the failure threshold")
+	}
+	ok := report.New()
+	ok.ExternalIP = "192.0.2.2"
+	ok.Add(report.RecordResult{Account: "Godaddy", Domain: "example.com", Name: "home", Type: "A", Outcome: report.Updated, Desired: "192.0.2.2", Observed: "192.0.2.1"})
+	ok.Finish()
+	tracker.Observe(ok)
+	if probe() != http.StatusOK {
+		t.Errorf("Tracker not ready after recovering")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	tracker := NewTracker(3)
+	tracker.Observe(failedReport())
+	next := time.Now().Add(time.Hour)
+	tracker.SetNextRun(next)
+	w := httptest.NewRecorder()
+	tracker.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	var s Status
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Status is not valid JSON: %s", err)
+	}
+	if s.NextRun == nil || !s.NextRun.Equal(next) {
+		t.Errorf("Next run not reported correctly: %v", s.NextRun)
+	}
+	if s.LastRunStatus != report.PartialFailure || len(s.Records) != 1 {
+		t.Fatalf("Unexpected status: %+v", s)
+	}
+	if s.Records[0].LastError != "API call failed" || s.Records[0].Desired != "192.0.2.2" {
+		t.Errorf("Record state not reported correctly: %+v", s.Records[0])
+	}
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 
@@ -12,16 +15,97 @@ const (
 	ifconfigURL = "http://ifconfig.io/ip"
 )
 
-func GetPublicIP() (ip string, err error) {
-	response, err := http.Get(ifconfigURL)
+// IPFamily returns the family of an address, ipv4 or ipv6, as named in metrics, events, history and status
+func IPFamily(address string) string {
+	ip := net.ParseIP(address)
+	if ip != nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+func GetPublicIP(ctx context.Context) (ip string, err error) {
+	return GetPublicIPNetwork(ctx, "tcp")
+}
+
+// GetPublicIPNetwork returns the public IP seen over a network: tcp4 for IPv4, tcp6 for IPv6, tcp for either
+func GetPublicIPNetwork(ctx context.Context, network string) (ip string, err error) {
+	return GetPublicIPVia(ctx, network, "", "")
+}
+
+// GetPublicIPVia returns the public IP seen by a service, default ifconfig.io, over a network.
+// When an interface is given the request leaves from its address, e.g. through a secondary WAN uplink
+func GetPublicIPVia(ctx context.Context, network string, service string, iface string) (ip string, err error) {
+	if service == "" {
+		service = ifconfigURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", service, nil)
+	if err != nil {
+		return "", err
+	}
+	client := http.DefaultClient
+	if network != "tcp" || iface != "" {
+		dialer := &net.Dialer{}
+		if iface != "" {
+			local, err := localAddress(iface, network)
+			if err != nil {
+				return "", err
+			}
+			dialer.LocalAddr = &net.TCPAddr{IP: local}
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = func(ctx context.Context, _ string, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		client = &http.Client{Transport: transport}
+	}
+	response, err := client.Do(req)
 	if err != nil {
 		log.Error(err)
 		return "", err
 	}
+	defer response.Body.Close()
 	responseData, err := io.ReadAll(response.Body)
 	if err != nil {
 		log.Error(err)
 		return "", err
 	}
-	return strings.TrimSuffix(string(responseData), "\n"), nil
+	return strings.TrimSpace(string(responseData)), nil
+}
+
+// InterfaceAddrs returns the addresses of a network interface of the host
+func InterfaceAddrs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// localAddress returns a global address of an interface usable over a network, to bind requests to it
+func localAddress(iface string, network string) (net.IP, error) {
+	ips, err := InterfaceAddrs(iface)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !ip.IsGlobalUnicast() {
+			continue
+		}
+		if (network == "tcp4" && ip.To4() == nil) || (network == "tcp6" && ip.To4() != nil) {
+			continue
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no address of interface %s to reach the internet over %s", iface, network)
 }